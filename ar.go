@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// arMagic is found at the start of ar archives, like .a and .deb files
+const arMagic = "!<arch>\n"
+
+// arThinMagic is found at the start of thin archives, where the members
+// are stored as separate files
+const arThinMagic = "!<thin>\n"
+
+const arHeaderSize = 60
+
+// maxArNamesSize is the largest GNU long name table, or BSD long name,
+// that is read. Real tables are much smaller, but the sizes in the headers
+// can not be trusted.
+const maxArNamesSize = 16 << 20
+
+// arMember is the header of a member in an ar archive
+type arMember struct {
+	Name string
+	Size int64
+}
+
+// arReader reads the members of an ar archive, in a streaming manner.
+// Both the GNU and the BSD variants of long member names are supported.
+type arReader struct {
+	r         io.Reader
+	remaining int64  // bytes left of the current member
+	pad       int64  // padding after the current member
+	longNames []byte // the GNU long name table, from the "//" member
+	thin      bool   // a thin archive, where the member data is not stored
+}
+
+// newArReader checks the ar magic and returns a new arReader
+func newArReader(r io.Reader) (*arReader, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != arMagic && string(magic) != arThinMagic {
+		return nil, errors.New("not an ar archive")
+	}
+	return &arReader{r: r, thin: string(magic) == arThinMagic}, nil
+}
+
+// Next skips to the next member and returns its header. The GNU symbol
+// table and long name table are handled internally and not returned.
+// For thin archives, the member name is the path of the member file,
+// relative to the archive, and there is no data to read.
+func (ar *arReader) Next() (*arMember, error) {
+	for {
+		// Skip the rest of the current member
+		if _, err := io.CopyN(ioutil.Discard, ar.r, ar.remaining+ar.pad); err != nil {
+			return nil, err
+		}
+		ar.remaining, ar.pad = 0, 0
+
+		var hdr [arHeaderSize]byte
+		if _, err := io.ReadFull(ar.r, hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("truncated ar header")
+			}
+			return nil, err
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, errors.New("invalid ar header")
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid ar member size")
+		}
+		ar.remaining, ar.pad = size, size%2
+		name := strings.TrimRight(string(hdr[:16]), " ")
+
+		switch {
+		case name == "/" || name == "/SYM64/" || name == "__.SYMDEF" || name == "__.SYMDEF SORTED":
+			// Symbol table
+			continue
+		case name == "//":
+			// GNU long name table
+			if size > maxArNamesSize {
+				return nil, errors.New("too large GNU ar long name table")
+			}
+			ar.longNames = make([]byte, size)
+			if _, err := io.ReadFull(ar.r, ar.longNames); err != nil {
+				return nil, err
+			}
+			ar.remaining = 0
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD long name, which is stored in front of the member data
+			length, err := strconv.ParseInt(name[3:], 10, 64)
+			if err != nil || length < 0 || length > size || length > maxArNamesSize {
+				return nil, errors.New("invalid BSD ar member name")
+			}
+			nameData := make([]byte, length)
+			if _, err := io.ReadFull(ar.r, nameData); err != nil {
+				return nil, err
+			}
+			ar.remaining -= length
+			name = string(bytes.TrimRight(nameData, "\x00"))
+			if name == "__.SYMDEF" || name == "__.SYMDEF SORTED" || name == "__.SYMDEF_64" {
+				continue
+			}
+		case len(name) > 1 && name[0] == '/' && ar.longNames != nil:
+			// GNU long name, as an offset into the long name table
+			offset, err := strconv.Atoi(name[1:])
+			if err != nil || offset < 0 || offset >= len(ar.longNames) {
+				return nil, errors.New("invalid GNU ar member name")
+			}
+			end := bytes.Index(ar.longNames[offset:], []byte("/\n"))
+			if end == -1 {
+				end = len(ar.longNames) - offset
+			}
+			name = string(ar.longNames[offset : offset+end])
+		default:
+			// GNU short names end with "/"
+			name = strings.TrimSuffix(name, "/")
+		}
+		size = ar.remaining
+		if ar.thin {
+			ar.remaining, ar.pad = 0, 0
+		}
+		return &arMember{Name: name, Size: size}, nil
+	}
+}
+
+// Read reads from the current member
+func (ar *arReader) Read(p []byte) (int, error) {
+	if ar.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > ar.remaining {
+		p = p[:ar.remaining]
+	}
+	n, err := ar.r.Read(p)
+	ar.remaining -= int64(n)
+	if err == io.EOF && ar.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// Input kinds that can be recognized by their first bytes
+const (
+	kindUnknown = ""
+	kindELF     = "elf"
+	kindGzip    = "gzip"
+	kindBzip2   = "bzip2"
+	kindXz      = "xz"
+	kindZstd    = "zstd"
+	kindTar     = "tar"
+	kindAr      = "ar"
+	kindRPM     = "rpm"
+	kindCpio    = "cpio"
+)
+
+// maxArchiveDepth is how deep archives within archives are examined
+const maxArchiveDepth = 8
+
+// sniffLength is the number of bytes needed by sniff
+const sniffLength = 512
+
+// ELF files within archives need random access. Files up to
+// maxMemberInMemory bytes are read into memory, and larger files are
+// written to a temporary file instead. Files larger than maxMemberSize,
+// like the ones from decompression bombs, are not examined.
+const (
+	maxMemberInMemory = 64 << 20
+	maxMemberSize     = 4 << 30
+)
+
+// readMember reads an ELF file within an archive, for random access. The
+// returned function removes the temporary file, if one was needed.
+func readMember(r io.Reader) (io.ReaderAt, func(), error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxMemberInMemory+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) <= maxMemberInMemory {
+		return bytes.NewReader(data), func() {}, nil
+	}
+	file, err := ioutil.TempFile("", "elfinfo-")
+	if err != nil {
+		return nil, nil, err
+	}
+	remove := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	if _, err := file.Write(data); err != nil {
+		remove()
+		return nil, nil, err
+	}
+	n, err := io.Copy(file, io.LimitReader(r, maxMemberSize-int64(len(data))+1))
+	if err == nil && int64(len(data))+n > maxMemberSize {
+		err = errTooLarge
+	}
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	return file, remove, nil
+}
+
+// sniff recognizes the kind of input from the first bytes
+func sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte(elf.ELFMAG)):
+		return kindELF
+	case bytes.HasPrefix(b, []byte{0x1f, 0x8b}):
+		return kindGzip
+	case bytes.HasPrefix(b, []byte("BZh")):
+		return kindBzip2
+	case bytes.HasPrefix(b, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		return kindXz
+	case bytes.HasPrefix(b, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return kindZstd
+	case bytes.HasPrefix(b, []byte(arMagic)), bytes.HasPrefix(b, []byte(arThinMagic)):
+		return kindAr
+	case bytes.HasPrefix(b, []byte{0xed, 0xab, 0xee, 0xdb}):
+		return kindRPM
+	case bytes.HasPrefix(b, []byte("070701")), bytes.HasPrefix(b, []byte("070702")):
+		return kindCpio
+	case len(b) >= 262 && string(b[257:262]) == "ustar":
+		return kindTar
+	}
+	return kindUnknown
+}
+
+// isContainer checks if the given kind of input may contain ELF files
+func isContainer(kind string) bool {
+	return kind != kindUnknown && kind != kindELF
+}
+
+// memberName returns the display name of a file within an archive, like
+// "pkg.deb!/usr/bin/foo"
+func memberName(archiveName, name string) string {
+	return archiveName + "!" + path.Join("/", name)
+}
+
+// archiveScanner looks for ELF files within compressed streams and archives
+type archiveScanner struct {
+	opts examineOptions
+	emit func(*result)
+	path string // the path of the top level file, for finding thin archive members
+}
+
+// archiveSummary summarizes the ELF members of an ar archive, like a static library
+type archiveSummary struct {
+	Members    int      `json:"members"`     // the number of members
+	ELFMembers int      `json:"elf_members"` // the number of members that are ELF files
+	Thin       bool     `json:"thin,omitempty"`
+	Toolchains []string `json:"toolchains"` // the distinct compilers, in the order they were found
+}
+
+// scan examines the ELF files in r, which is named name. If r is neither an
+// ELF file nor a known archive or compressed stream, nothing is emitted,
+// unless depth is 0, in which case a "not an ELF" result is emitted.
+func (s *archiveScanner) scan(name string, r io.Reader, depth int) error {
+	if depth > maxArchiveDepth {
+		return nil
+	}
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(sniffLength)
+	kind := sniff(head)
+	switch kind {
+	case kindELF:
+		ra, remove, err := readMember(br)
+		if err == errTooLarge {
+			// The rest of the archive can still be examined
+			s.emit(&result{Filename: name, Error: err.Error(), err: err, inArchive: depth > 0})
+			return nil
+		}
+		if err != nil {
+			// Name the member, if the error is not for the file itself
+			if name != s.path {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return err
+		}
+		defer remove()
+		res := examineReaderAt(ra, name, s.opts)
+		res.inArchive = depth > 0
+		s.emit(res)
+		return nil
+	case kindGzip, kindBzip2, kindXz, kindZstd:
+		rc, err := decompress(br, kind)
+		if err != nil {
+			// Name the member, if the error is not for the file itself
+			if name != s.path {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return err
+		}
+		scanErr := s.scan(name, rc, depth+1)
+		if err := rc.Close(); err != nil && scanErr == nil {
+			return err
+		}
+		return scanErr
+	case kindTar:
+		return s.scanTar(name, br, depth+1)
+	case kindAr:
+		return s.scanAr(name, br, depth+1)
+	case kindRPM:
+		return s.scanRPM(name, br, depth+1)
+	case kindCpio:
+		return s.scanCpio(name, br, depth+1)
+	}
+	if depth == 0 {
+		s.emit(&result{Filename: name, Error: errNotELF.Error(), err: errNotELF})
+	}
+	return nil
+}
+
+// decompress returns a reader for the decompressed data in r, which is
+// compressed as the given kind of input
+func decompress(r io.Reader, kind string) (io.ReadCloser, error) {
+	switch kind {
+	case kindGzip:
+		return gzip.NewReader(r)
+	case kindBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case kindXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case kindZstd:
+		// The files are already examined in parallel, so one goroutine is enough
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%s compressed data is not supported", kind)
+}
+
+// scanTar examines every regular file in a tar archive
+func (s *archiveScanner) scanTar(name string, r io.Reader, depth int) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := s.scan(memberName(name, hdr.Name), tr, depth); err != nil {
+			return err
+		}
+	}
+}
+
+// scanAr examines the members of an ar archive. For Debian packages, only
+// the data.tar member is examined, and the member name is left out of the
+// display name, so that files are named like "pkg.deb!/usr/bin/foo".
+// For other archives, like static libraries, every member is examined and
+// a summary of the distinct toolchains is emitted after the members.
+func (s *archiveScanner) scanAr(name string, r io.Reader, depth int) error {
+	ar, err := newArReader(r)
+	if err != nil {
+		return err
+	}
+	summary := &archiveSummary{Thin: ar.thin, Toolchains: []string{}}
+	seen := make(map[string]bool)
+	// Collect the toolchains while passing the member results on
+	emit := s.emit
+	memberScanner := *s
+	memberScanner.emit = func(res *result) {
+		if res.err == nil {
+			summary.ELFMembers++
+			compilers := res.Compilers
+			if compilers == nil && res.CompilerInfo != nil {
+				compilers = []*compiler.Info{res.CompilerInfo}
+			}
+			for _, info := range compilers {
+				if toolchain := info.String(); !seen[toolchain] {
+					seen[toolchain] = true
+					summary.Toolchains = append(summary.Toolchains, toolchain)
+				}
+			}
+		}
+		emit(res)
+	}
+	first := true
+	deb := false
+	for {
+		member, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first && member.Name == "debian-binary" {
+			deb = true
+		}
+		first = false
+		if deb {
+			if strings.HasPrefix(member.Name, "data.tar") {
+				if err := s.scan(name, ar, depth); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		summary.Members++
+		if ar.thin {
+			if err := memberScanner.scanThinMember(name, member.Name, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := memberScanner.scan(name+"!"+member.Name, ar, depth); err != nil {
+			return err
+		}
+	}
+	if !deb {
+		emit(&result{Filename: name, Archive: summary})
+	}
+	return nil
+}
+
+// errThinMemberPath is the error for thin archive members that are not
+// within the directory of the archive
+var errThinMemberPath = errors.New("thin archive member is outside of the archive directory")
+
+// scanThinMember examines a member of a thin archive, which is stored as a
+// separate file, relative to the archive. This is only possible for thin
+// archives that are not within other archives. Absolute paths and paths
+// with ".." are not followed, so that an archive can not make elfinfo read
+// other files.
+func (s *archiveScanner) scanThinMember(name, memberPath string, depth int) error {
+	if depth != 1 || s.path == "" {
+		return nil
+	}
+	if filepath.IsAbs(memberPath) || path.IsAbs(memberPath) || hasDotDot(memberPath) {
+		s.emit(&result{Filename: name + "!" + memberPath, Error: errThinMemberPath.Error(), err: errThinMemberPath})
+		return nil
+	}
+	memberPath = filepath.Join(filepath.Dir(s.path), memberPath)
+	file, err := os.Open(memberPath)
+	if err != nil {
+		s.emit(&result{Filename: name + "!" + memberPath, Error: err.Error(), err: err})
+		return nil
+	}
+	defer file.Close()
+	return s.scan(name+"!"+memberPath, file, depth)
+}
+
+// hasDotDot checks if a path has a ".." element
+func hasDotDot(p string) bool {
+	for _, elem := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == filepath.Separator }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// scanRPM skips the RPM lead, signature and header, and examines the
+// payload, which is a compressed cpio archive
+func (s *archiveScanner) scanRPM(name string, r io.Reader, depth int) error {
+	// The lead is 96 bytes
+	if _, err := io.CopyN(ioutil.Discard, r, 96); err != nil {
+		return err
+	}
+	// The signature header is padded to 8 bytes, the main header is not
+	for _, pad := range []bool{true, false} {
+		var hdr [16]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(hdr[:], []byte{0x8e, 0xad, 0xe8}) {
+			return errors.New("invalid RPM header")
+		}
+		count := int64(hdr[8])<<24 | int64(hdr[9])<<16 | int64(hdr[10])<<8 | int64(hdr[11])
+		size := int64(hdr[12])<<24 | int64(hdr[13])<<16 | int64(hdr[14])<<8 | int64(hdr[15])
+		skip := count*16 + size
+		if pad {
+			skip += (8 - (16+skip)%8) % 8
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, skip); err != nil {
+			return err
+		}
+	}
+	return s.scan(name, r, depth)
+}
+
+// maxCpioNameSize is the longest file name in a cpio archive that is accepted
+const maxCpioNameSize = 64 * 1024
+
+// scanCpio examines every regular file in a cpio archive in the "newc"
+// format, which is used for RPM payloads
+func (s *archiveScanner) scanCpio(name string, r io.Reader, depth int) error {
+	const headerSize = 110
+	// The header and the name, and the file data, are each padded to 4 bytes
+	pad := func(n int64) int64 { return (4 - n%4) % 4 }
+	for {
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(hdr[:], []byte("07070")) {
+			return errors.New("invalid cpio header")
+		}
+		// The 13 fields are unsigned hex numbers, so that sizes can not be negative
+		var fields [13]int64
+		for i := range fields {
+			n, err := strconv.ParseUint(string(hdr[6+i*8:6+(i+1)*8]), 16, 32)
+			if err != nil {
+				return errors.New("invalid cpio header")
+			}
+			fields[i] = int64(n)
+		}
+		mode, fileSize, nameSize := fields[1], fields[6], fields[11]
+		if nameSize > maxCpioNameSize {
+			return errors.New("invalid cpio header")
+		}
+		nameData := make([]byte, nameSize+pad(headerSize+nameSize))
+		if _, err := io.ReadFull(r, nameData); err != nil {
+			return err
+		}
+		entryName := string(bytes.TrimRight(nameData[:nameSize], "\x00"))
+		if entryName == "TRAILER!!!" {
+			return nil
+		}
+		data := io.LimitReader(r, fileSize)
+		// Only regular files
+		if mode&0170000 == 0100000 {
+			if err := s.scan(memberName(name, entryName), data, depth); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(ioutil.Discard, data); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, pad(fileSize)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// maxAuditDataSize is the largest decompressed .dep-v0 section that is read
+const maxAuditDataSize = 8 * 1024 * 1024
+
+// rustPackage is a crate that is embedded in a Rust executable, as recorded
+// by cargo-auditable in the .dep-v0 section
+type rustPackage struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Source       string `json:"source"`                 // like "crates.io", "local" or "git"
+	Kind         string `json:"kind,omitempty"`         // "runtime" or "build"
+	Dependencies []int  `json:"dependencies,omitempty"` // indexes of other packages in the list
+	Root         bool   `json:"root,omitempty"`         // the crate that was built
+}
+
+// rustPackages reads the list of crates from the .dep-v0 section, which is
+// zlib compressed JSON
+func rustPackages(f *elf.File) ([]rustPackage, error) {
+	data := compiler.SectionData(f, ".dep-v0")
+	if data == nil {
+		return nil, errors.New("no .dep-v0 section")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	decompressed, err := ioutil.ReadAll(io.LimitReader(zr, maxAuditDataSize))
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Packages []rustPackage `json:"packages"`
+	}
+	if err := json.Unmarshal(decompressed, &info); err != nil {
+		return nil, err
+	}
+	return info.Packages, nil
+}
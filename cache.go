@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// cacheFormat is the version of the cache layout and entries. It must be
+// increased if entries written by older versions can not be used.
+const cacheFormat = 1
+
+// resultCache stores the results of examining files on disk. The results
+// are stored by the SHA-256 of the file contents, the detector version and
+// the examine options, so that copies of a file share an entry and entries
+// written by other versions of elfinfo or ainur are not used. To avoid
+// hashing unchanged files again, the SHA-256 is also stored by the device,
+// inode, size and modification time of each file.
+type resultCache struct {
+	dir     string
+	version string // the detector version and the examine options
+}
+
+// cachedResult is a result in a cache entry, with the fields that are not
+// in the JSON output
+type cachedResult struct {
+	Result    *result   `json:"result"`
+	InArchive bool      `json:"in_archive,omitempty"`
+	Hashes    *hashInfo `json:"hashes,omitempty"`
+}
+
+// cacheEntry is the results of examining a file
+type cacheEntry struct {
+	Version  string         `json:"version"`
+	Filename string         `json:"filename"` // the filename the results were stored for
+	Results  []cachedResult `json:"results"`
+}
+
+// statEntry is the SHA-256 of a file, as long as the size and modification
+// time are unchanged
+type statEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // in nanoseconds since the epoch
+	SHA256  string `json:"sha256"`
+}
+
+// defaultCacheDir returns the cache directory, which is
+// $XDG_CACHE_HOME/elfinfo or ~/.cache/elfinfo on Linux
+func defaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "elfinfo"), nil
+}
+
+// detectorVersion identifies the detectors, by the version of elfinfo, the
+// modules it was built with, like ainur, and the registered detectors,
+// including the loaded signatures
+func detectorVersion() string {
+	version := fmt.Sprintf("%s, cache format %d, detectors %s", versionString, cacheFormat, compiler.Fingerprint())
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	modules := []string{info.Main.Path + " " + info.Main.Version}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		modules = append(modules, strings.TrimSpace(dep.Path+" "+dep.Version+" "+dep.Sum))
+	}
+	return version + ", " + strings.Join(modules, ", ")
+}
+
+// newResultCache creates the cache directory, if needed. Results are only
+// used for the same examine options.
+func newResultCache(dir string, opts examineOptions, image bool) (*resultCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &resultCache{dir: dir, version: fmt.Sprintf("%s, options %+v, image %v", detectorVersion(), opts, image)}, nil
+}
+
+// hashKey returns the hex encoded SHA-256 of the given strings
+func hashKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// entryPath returns the path of a cache file, in a subdirectory named after
+// the first two hex digits of the key
+func (c *resultCache) entryPath(kind, key string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("v%d", cacheFormat), kind, key[:2], key+".json")
+}
+
+// read decodes a cache file
+func (c *resultCache) read(filename string, v interface{}) bool {
+	data, err := ioutil.ReadFile(filename)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// write encodes a cache file. The file is written to a temporary file that
+// is then renamed, so that files are never read while they are written.
+// Errors are ignored, since the cache is only used for speed.
+func (c *resultCache) write(filename string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), filename) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the given file
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// worthCaching checks if a file is an ELF file or may contain ELF files.
+// Other files are skipped quickly, which is faster than hashing them.
+func worthCaching(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, sniffLength)
+	n, _ := f.ReadAt(head, 0)
+	kind := sniff(head[:n])
+	return kind == kindELF || isContainer(kind)
+}
+
+// fileSum returns the SHA-256 of a file, from the cache if the file is
+// unchanged. An empty string is returned if the file should not be cached.
+func (c *resultCache) fileSum(filename string) string {
+	fi, err := os.Stat(filename)
+	if err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	dev, ino, hasIdentity := fileIdentity(fi)
+	var statPath string
+	if hasIdentity {
+		statPath = c.entryPath("files", hashKey(fmt.Sprintf("%d:%d", dev, ino)))
+		var e statEntry
+		if c.read(statPath, &e) && e.Size == fi.Size() && e.ModTime == fi.ModTime().UnixNano() {
+			return e.SHA256
+		}
+	}
+	if !worthCaching(filename) {
+		return ""
+	}
+	sum, err := fileSHA256(filename)
+	if err != nil {
+		return ""
+	}
+	if hasIdentity {
+		c.write(statPath, statEntry{Size: fi.Size(), ModTime: fi.ModTime().UnixNano(), SHA256: sum})
+	}
+	return sum
+}
+
+// load returns the cached results for a file, with the filenames changed
+// to the given filename
+func (c *resultCache) load(entryPath, filename string) ([]*result, bool) {
+	var e cacheEntry
+	if !c.read(entryPath, &e) || e.Version != c.version {
+		return nil, false
+	}
+	results := make([]*result, 0, len(e.Results))
+	for _, cached := range e.Results {
+		res := cached.Result
+		if res == nil {
+			return nil, false
+		}
+		if strings.HasPrefix(res.Filename, e.Filename) {
+			res.Filename = filename + res.Filename[len(e.Filename):]
+		}
+		res.inArchive = cached.InArchive
+		res.Hashes = cached.Hashes
+		switch res.Error {
+		case "":
+		case errNotELF.Error():
+			res.err = errNotELF
+		default:
+			res.err = errors.New(res.Error)
+		}
+		results = append(results, res)
+	}
+	return results, true
+}
+
+// store writes the results for a file, unless there were errors that may
+// not happen the next time, like read errors
+func (c *resultCache) store(entryPath, filename string, results []*result) {
+	e := cacheEntry{Version: c.version, Filename: filename, Results: make([]cachedResult, 0, len(results))}
+	for _, res := range results {
+		if res.err != nil && res.err != errNotELF {
+			return
+		}
+		e.Results = append(e.Results, cachedResult{Result: res, InArchive: res.inArchive, Hashes: res.Hashes})
+	}
+	c.write(entryPath, e)
+}
+
+// examine calls emit with the cached results for the given file, if any.
+// Otherwise, the file is examined with the given function and the results
+// are stored.
+func (c *resultCache) examine(filename string, examine func(string, func(*result)), emit func(*result)) {
+	sum := ""
+	if filename != "-" {
+		sum = c.fileSum(filename)
+	}
+	if sum == "" {
+		examine(filename, emit)
+		return
+	}
+	entryPath := c.entryPath("results", hashKey(c.version, sum))
+	if results, ok := c.load(entryPath, filename); ok {
+		for _, res := range results {
+			emit(res)
+		}
+		return
+	}
+	var results []*result
+	examine(filename, func(res *result) {
+		results = append(results, res)
+	})
+	c.store(entryPath, filename, results)
+	for _, res := range results {
+		emit(res)
+	}
+}
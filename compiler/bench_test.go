@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/xyproto/ainur"
+)
+
+// benchmarkFiles returns the ELF files to benchmark the detection with: the
+// test binary itself, and the files listed in $ELFINFO_BENCH_FILES, for
+// example large binaries with big .rodata and .debug_str sections
+func benchmarkFiles() []string {
+	files := []string{os.Args[0]}
+	for _, name := range filepath.SplitList(os.Getenv("ELFINFO_BENCH_FILES")) {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// openBenchmarkFile opens an ELF file for a benchmark, or skips the benchmark
+func openBenchmarkFile(b *testing.B, name string) (*os.File, *elf.File) {
+	file, err := os.Open(name)
+	if err != nil {
+		b.Skip(err)
+	}
+	f, err := elf.NewFile(file)
+	if err != nil {
+		file.Close()
+		b.Skip(err)
+	}
+	return file, f
+}
+
+// BenchmarkDetect compares the detectors with the string detection of
+// ainur, which they were forked from, and which searches each section once
+// for each pattern
+func BenchmarkDetect(b *testing.B) {
+	for _, name := range benchmarkFiles() {
+		b.Run("ainur/"+filepath.Base(name), func(b *testing.B) {
+			file, f := openBenchmarkFile(b, name)
+			defer file.Close()
+			for i := 0; i < b.N; i++ {
+				ainur.Compiler(f)
+			}
+		})
+		b.Run("detect/"+filepath.Base(name), func(b *testing.B) {
+			file, f := openBenchmarkFile(b, name)
+			defer file.Close()
+			for i := 0; i < b.N; i++ {
+				e := NewFile(f, file)
+				Detect(e)
+				e.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkSectionSearch measures the search for the detector patterns in
+// the sections of real files, in memory and streamed
+func BenchmarkSectionSearch(b *testing.B) {
+	var sections []string
+	for section := range sectionMatchers {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, name := range benchmarkFiles() {
+		for _, section := range sections {
+			m := sectionMatchers[section]
+			load := func(b *testing.B) []byte {
+				file, f := openBenchmarkFile(b, name)
+				defer file.Close()
+				sec := f.Section(section)
+				if sec == nil {
+					b.Skip("no ", section, " section")
+				}
+				data, err := sec.Data()
+				if err != nil || len(data) == 0 {
+					b.Skip("no ", section, " section")
+				}
+				b.SetBytes(int64(len(data)))
+				b.ResetTimer()
+				return data
+			}
+			b.Run("scan/"+filepath.Base(name)+section, func(b *testing.B) {
+				data := load(b)
+				for i := 0; i < b.N; i++ {
+					m.scan(data, 0, func(int, int64) bool { return true })
+				}
+			})
+			b.Run("scanReader/"+filepath.Base(name)+section, func(b *testing.B) {
+				data := load(b)
+				for i := 0; i < b.N; i++ {
+					m.scanReader(bytes.NewReader(data), 0, func(int, int64) bool { return true })
+				}
+			})
+		}
+	}
+}
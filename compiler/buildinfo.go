@@ -0,0 +1,221 @@
+package compiler
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// buildInfoMagic is found at the start of the .go.buildinfo section
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+const (
+	buildInfoHeaderSize = 32
+	buildInfoBigEndian  = 0x1 // flag for big endian pointers
+	buildInfoInline     = 0x2 // flag for inline strings, used since Go 1.18
+)
+
+// maxBuildInfoSize is the most data that is read for the build info, when
+// it is searched for in the segments
+const maxBuildInfoSize = 1 << 20
+
+var errNoBuildInfo = errors.New("no Go build info")
+
+// GoModule is a Go module, as recorded in the build info
+type GoModule struct {
+	Path    string    `json:"path"`
+	Version string    `json:"version,omitempty"`
+	Sum     string    `json:"sum,omitempty"`
+	Replace *GoModule `json:"replace,omitempty"` // the module that replaces this one, if any
+}
+
+// String returns the module path and version, and the replacement if any
+func (m *GoModule) String() string {
+	s := strings.TrimSpace(m.Path + " " + m.Version)
+	if m.Replace != nil {
+		s += " => " + m.Replace.String()
+	}
+	return s
+}
+
+// GoBuildSetting is a key/value build setting, like CGO_ENABLED=1
+type GoBuildSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GoBuildInfo is the build information that the Go toolchain embeds in
+// the .go.buildinfo section of executables
+type GoBuildInfo struct {
+	GoVersion string           `json:"go_version"`     // like "go1.21.0"
+	Path      string           `json:"path,omitempty"` // the package path of the main package
+	Main      *GoModule        `json:"main,omitempty"` // the main module
+	Deps      []*GoModule      `json:"deps,omitempty"`
+	Settings  []GoBuildSetting `json:"settings,omitempty"`
+}
+
+// ReadGoBuildInfo decodes the Go build info from the given ELF file.
+// Both the inline format used since Go 1.18 and the older pointer based
+// format are supported.
+func ReadGoBuildInfo(f *elf.File) (*GoBuildInfo, error) {
+	data := SectionData(f, ".go.buildinfo")
+	if data == nil {
+		data = findBuildInfo(f)
+	}
+	if len(data) < buildInfoHeaderSize || !bytes.HasPrefix(data, buildInfoMagic) {
+		return nil, errNoBuildInfo
+	}
+	ptrSize := int(data[14])
+	flags := data[15]
+
+	var goVersion, modInfo string
+	if flags&buildInfoInline != 0 {
+		rest := data[buildInfoHeaderSize:]
+		goVersion, rest = readVarintString(rest)
+		modInfo, _ = readVarintString(rest)
+	} else {
+		if ptrSize != 4 && ptrSize != 8 {
+			return nil, errNoBuildInfo
+		}
+		var bo binary.ByteOrder = binary.LittleEndian
+		if flags&buildInfoBigEndian != 0 {
+			bo = binary.BigEndian
+		}
+		readPtr := func(b []byte) uint64 {
+			if ptrSize == 4 {
+				return uint64(bo.Uint32(b))
+			}
+			return bo.Uint64(b)
+		}
+		// The header is followed by pointers to the two string headers
+		if len(data) < 16+2*ptrSize {
+			return nil, errNoBuildInfo
+		}
+		readString := func(addr uint64) string {
+			hdr := readAddr(f, addr, 2*ptrSize)
+			if hdr == nil {
+				return ""
+			}
+			return string(readAddr(f, readPtr(hdr), int(readPtr(hdr[ptrSize:]))))
+		}
+		goVersion = readString(readPtr(data[16:]))
+		modInfo = readString(readPtr(data[16+ptrSize:]))
+	}
+	if goVersion == "" {
+		return nil, errNoBuildInfo
+	}
+
+	info := &GoBuildInfo{GoVersion: goVersion}
+	// The module info is surrounded by 16 byte sentinels
+	if len(modInfo) >= 33 && modInfo[len(modInfo)-17] == '\n' {
+		modInfo = modInfo[16 : len(modInfo)-16]
+	}
+	parseModInfo(info, modInfo)
+	return info, nil
+}
+
+// parseModInfo parses the text format of the module info, which is the
+// same as the output of "go version -m"
+func parseModInfo(info *GoBuildInfo, modInfo string) {
+	var last *GoModule
+	for _, line := range strings.Split(modInfo, "\n") {
+		fields := strings.Split(line, "\t")
+		switch {
+		case len(fields) >= 2 && fields[0] == "path":
+			info.Path = fields[1]
+		case len(fields) >= 3 && (fields[0] == "mod" || fields[0] == "dep" || fields[0] == "=>"):
+			m := &GoModule{Path: fields[1], Version: fields[2]}
+			if len(fields) >= 4 {
+				m.Sum = fields[3]
+			}
+			switch fields[0] {
+			case "mod":
+				info.Main = m
+			case "dep":
+				info.Deps = append(info.Deps, m)
+			case "=>":
+				if last != nil {
+					last.Replace = m
+				}
+			}
+			last = m
+		case len(fields) >= 2 && fields[0] == "build":
+			kv := strings.SplitN(fields[1], "=", 2)
+			setting := GoBuildSetting{Key: kv[0]}
+			if len(kv) == 2 {
+				setting.Value = kv[1]
+			}
+			info.Settings = append(info.Settings, setting)
+		}
+	}
+}
+
+// readVarintString reads a string prefixed with an unsigned varint length,
+// and returns the string and the remaining bytes
+func readVarintString(b []byte) (string, []byte) {
+	length, n := binary.Uvarint(b)
+	if n <= 0 || length > uint64(len(b)-n) {
+		return "", nil
+	}
+	return string(b[n : n+int(length)]), b[n+int(length):]
+}
+
+// readAddr reads size bytes at the given virtual address, from the
+// loadable segment that contains it. Returns nil if the data is not available.
+func readAddr(f *elf.File, addr uint64, size int) []byte {
+	if size < 0 || size > 1<<20 {
+		return nil
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr+uint64(size) > prog.Vaddr+prog.Filesz {
+			continue
+		}
+		buf := make([]byte, size)
+		if _, err := prog.ReadAt(buf, int64(addr-prog.Vaddr)); err != nil {
+			return nil
+		}
+		return buf
+	}
+	return nil
+}
+
+// findBuildInfo searches the writable loadable segments for the build
+// info magic, for executables without section headers. The segments are
+// streamed through the matcher, and only the data after the magic is read.
+func findBuildInfo(f *elf.File) []byte {
+	if len(f.Sections) > 0 {
+		return nil
+	}
+	m := newMatcher([]string{string(buildInfoMagic)})
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Flags&elf.PF_W == 0 {
+			continue
+		}
+		// The build info is 16 byte aligned
+		pos := int64(-1)
+		m.scanReader(prog.Open(), 0, func(_ int, offset int64) bool {
+			if offset%16 != 0 {
+				return true
+			}
+			pos = offset
+			return false
+		})
+		if pos == -1 {
+			continue
+		}
+		size := int64(prog.Filesz) - pos
+		if size > maxBuildInfoSize {
+			size = maxBuildInfoSize
+		}
+		data := make([]byte, size)
+		n, err := prog.ReadAt(data, pos)
+		if err != nil && err != io.EOF {
+			continue
+		}
+		return data[:n]
+	}
+	return nil
+}
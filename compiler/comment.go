@@ -0,0 +1,65 @@
+package compiler
+
+import (
+	"bytes"
+	"debug/elf"
+	"regexp"
+	"strings"
+)
+
+// Producer is a single NUL-terminated entry in the .comment section,
+// together with the producer name and version that could be parsed from it
+type Producer struct {
+	Entry   string `json:"entry"`             // the raw entry, like "GCC: (GNU) 9.2.1 20200130"
+	Name    string `json:"producer"`          // the producer name, like "GCC"
+	Version string `json:"version,omitempty"` // the producer version, like "9.2.1"
+}
+
+// producerRules are patterns for known producer strings, where the first
+// submatch is the version. They are tried in order.
+var producerRules = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"GCC", regexp.MustCompile(`^GCC: \(.*?\) (\d+(?:\.\d+)*)`)},
+	{"GCC", regexp.MustCompile(`^GCC: (\d+(?:\.\d+)*)`)},
+	{"Clang", regexp.MustCompile(`clang version (\d+(?:\.\d+)*)`)},
+	{"LLD", regexp.MustCompile(`^Linker: LLD (\d+(?:\.\d+)*)`)},
+	{"mold", regexp.MustCompile(`^mold (\d+(?:\.\d+)*)`)},
+	{"Rust", regexp.MustCompile(`^rustc version (\d+(?:\.\d+)*)`)},
+	{"GHC", regexp.MustCompile(`^GHC (\d+(?:\.\d+)*)`)},
+}
+
+// producerVersionRegex is used for finding a version in unknown producer strings
+var producerVersionRegex = regexp.MustCompile(`\d+\.\d+(?:\.\d+)*`)
+
+// parseProducer parses a single .comment entry. For unknown producers,
+// the text in front of the first version number is used as the name.
+func parseProducer(entry string) Producer {
+	for _, rule := range producerRules {
+		if m := rule.re.FindStringSubmatch(entry); m != nil {
+			return Producer{Entry: entry, Name: rule.name, Version: m[1]}
+		}
+	}
+	loc := producerVersionRegex.FindStringIndex(entry)
+	if loc == nil {
+		return Producer{Entry: entry, Name: entry}
+	}
+	name := strings.TrimRight(strings.TrimSpace(entry[:loc[0]]), ":(")
+	if name == "" {
+		name = entry
+	}
+	return Producer{Entry: entry, Name: strings.TrimSpace(name), Version: entry[loc[0]:loc[1]]}
+}
+
+// Producers returns every non-empty NUL-separated entry in the .comment
+// section, in order, including duplicates
+func Producers(f *elf.File) []Producer {
+	var entries []Producer
+	for _, entry := range bytes.Split(SectionData(f, ".comment"), []byte{0}) {
+		if entry = bytes.TrimSpace(entry); len(entry) > 0 {
+			entries = append(entries, parseProducer(string(entry)))
+		}
+	}
+	return entries
+}
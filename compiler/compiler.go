@@ -0,0 +1,443 @@
+// Package compiler detects which compilers and toolchains an ELF file was
+// built with, like GCC, Clang, Go or Rust, together with the version and
+// the evidence that was found. The built-in detectors can be extended with
+// more detectors or declarative signatures, with Register.
+package compiler
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/ainur"
+)
+
+const (
+	gccMarker   = "GCC: ("
+	gnuEnding   = "GNU) "
+	clangMarker = "clang version"
+	rustMarker  = "rustc version"
+	ghcMarker   = "GHC "
+	ocamlMarker = "[ocaml]"
+	goMarker    = "go1."
+	dmdMarker   = "__dmd_"
+	fpcMarker   = "FPC "
+
+	// rustcPathMarker is in the paths to the Rust standard library in new executables
+	rustcPathMarker = "/rustc-"
+	// rustSymbolMarker is the start of symbol names that are in old executables
+	rustSymbolMarker = "__rust_"
+
+	// versionWindow is how far from a marker a version number is searched for
+	versionWindow = 4096
+)
+
+// BufferSize is the size of the chunks that data is read in, when it is
+// streamed instead of read all at once
+const BufferSize = 8192
+
+// semVerRegex is a regexp for picking out the numeric parts of a version string
+var semVerRegex = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// SemVer is a parsed version number. Missing parts are zero.
+type SemVer struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+// ParseSemVer parses the leading "major.minor.patch" part of a version
+// string. Returns nil if the version does not start with a number.
+func ParseSemVer(version string) *SemVer {
+	m := semVerRegex.FindStringSubmatch(version)
+	if m == nil {
+		return nil
+	}
+	var parts [3]int
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(m[i+1])
+	}
+	return &SemVer{Major: parts[0], Minor: parts[1], Patch: parts[2]}
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than other
+func (v *SemVer) Compare(other *SemVer) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		switch {
+		case d < 0:
+			return -1
+		case d > 0:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Confidence levels for compiler detections
+const (
+	// ConfidenceHigh is used when the compiler identifies itself with a
+	// literal producer string, like the ones in the .comment section
+	ConfidenceHigh = "high"
+	// ConfidenceMedium is used when a version or marker pattern is found
+	// in a section that may also contain unrelated strings
+	ConfidenceMedium = "medium"
+	// ConfidenceLow is used when the compiler is guessed from indirect
+	// evidence, like which sections are present
+	ConfidenceLow = "low"
+)
+
+// Info describes a detected compiler and where it was found
+type Info struct {
+	Name       string  `json:"name"`               // compiler family, like "GCC" or "Rust"
+	Version    string  `json:"version,omitempty"`  // compiler version, like "9.2.1"
+	SemVer     *SemVer `json:"semver,omitempty"`   // the parsed compiler version
+	Linker     string  `json:"linker,omitempty"`   // toolchain used for linking, like "GCC 8.1.0"
+	Section    string  `json:"section,omitempty"`  // the ELF section the evidence was found in
+	Evidence   string  `json:"evidence,omitempty"` // the raw bytes that were matched
+	Confidence string  `json:"confidence"`         // how strong the evidence is: high, medium or low
+}
+
+// NewInfo creates a new Info and parses the version, if given
+func NewInfo(name, version, section string, evidence []byte, confidence string) *Info {
+	return &Info{
+		Name:       name,
+		Version:    version,
+		SemVer:     ParseSemVer(version),
+		Section:    section,
+		Evidence:   string(evidence),
+		Confidence: confidence,
+	}
+}
+
+// String returns the compiler name and version on the same form as
+// ainur.Compiler, for example "GCC 9.2.1" or "Rust (GCC 8.1.0)"
+func (c *Info) String() string {
+	if c == nil {
+		return "unknown"
+	}
+	if c.Version != "" {
+		return c.Name + " " + c.Version
+	}
+	if c.Linker != "" {
+		return c.Name + " (" + c.Linker + ")"
+	}
+	return c.Name
+}
+
+// The built-in compiler detectors, ordered from the more specific to the
+// more ambiguous ones. The priorities leave room for other detectors in
+// between.
+func init() {
+	for _, d := range []*Detector{
+		{Name: "Go", Priority: 90, Markers: []Marker{{".rodata", goMarker}}, Detect: goCompiler},
+		{Name: "OCaml", Priority: 80, Markers: []Marker{{".rodata", ocamlMarker}}, Detect: ocamlCompiler},
+		{Name: "GHC", Priority: 70, Detect: ghcCompiler},
+		{Name: "Rust", Priority: 60, Markers: []Marker{{".debug_str", rustMarker}}, Detect: rustCompilerUnstripped},
+		{Name: "Rust", Priority: 50, Markers: []Marker{{".rodata", rustcPathMarker}, {".rodata", rustSymbolMarker}}, Detect: rustCompilerStripped},
+		mustDetector(Signature{Name: "DMD", Section: ".dynstr", Marker: dmdMarker, Pattern: dmdMarker, Priority: 40}),
+		{Name: "GCC", Priority: 30, Detect: gccCompiler},
+		mustDetector(Signature{Name: "FPC", Section: ".data", Marker: fpcMarker, Pattern: `FPC (?P<version>(\d+\.)?(\d+\.)?(\*|\d+))`, Priority: 20}),
+		{Name: "TCC", Priority: 10, Detect: tccCompiler},
+	} {
+		Register(d)
+	}
+}
+
+// FromReaderAt detects the compiler of the ELF data in r. name is only used
+// in errors, and can be a filename or any other description of the data.
+// Returns nil if no compiler could be detected.
+func FromReaderAt(r io.ReaderAt, name string) (*Info, error) {
+	ef, err := openReaderAt(r, name)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+	return Detect(ef)
+}
+
+// AllFromReaderAt is like FromReaderAt, but returns every toolchain that
+// was found, like DetectAll
+func AllFromReaderAt(r io.ReaderAt, name string) ([]*Info, error) {
+	ef, err := openReaderAt(r, name)
+	if err != nil {
+		return nil, err
+	}
+	defer ef.Close()
+	return DetectAll(ef)
+}
+
+// openReaderAt parses the ELF data in r, for FromReaderAt and AllFromReaderAt
+func openReaderAt(r io.ReaderAt, name string) (*File, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return NewFile(f, r), nil
+}
+
+// Detect tries to find which compiler and version the given ELF
+// file was compiled with. Returns nil if no compiler could be detected, and
+// an error if a section that is searched could not be read.
+func Detect(f *File) (*Info, error) {
+	for _, d := range detectors {
+		if info, err := d.Detect(f); info != nil || err != nil {
+			return info, err
+		}
+	}
+	return nil, nil
+}
+
+// Compiler returns the compiler and version the given ELF file was compiled
+// with as a string, or "unknown", like ainur.Compiler. It is a thin wrapper
+// over Detect, for callers that only need the string.
+func Compiler(f *elf.File) string {
+	ef := NewFile(f, nil)
+	defer ef.Close()
+	// Files that could not be read are reported as "unknown"
+	info, _ := Detect(ef)
+	return info.String()
+}
+
+// DetectAll runs every compiler detector and returns every toolchain
+// that was found, for binaries that mix several languages. Only the first
+// detection of each compiler family is kept.
+func DetectAll(f *File) ([]*Info, error) {
+	var infos []*Info
+	seen := make(map[string]bool)
+	for _, d := range detectors {
+		info, err := d.Detect(f)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || seen[info.Name] {
+			continue
+		}
+		seen[info.Name] = true
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// MaxSectionSize is the largest section that SectionData reads. The sizes
+// in the section headers can not be trusted, and compressed sections can
+// be much larger than the file.
+const MaxSectionSize = 256 << 20
+
+// SectionData returns the contents of the given section, or nil if the
+// section is missing, could not be read or is larger than MaxSectionSize
+func SectionData(f *elf.File, name string) []byte {
+	sec := f.Section(name)
+	if sec == nil || sec.Size > MaxSectionSize || sec.FileSize > MaxSectionSize {
+		return nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// commentEntry returns the NUL-terminated string in data that contains
+// the given needle, or the needle itself if it could not be found
+func commentEntry(data, needle []byte) []byte {
+	pos := bytes.Index(data, needle)
+	if pos == -1 {
+		return needle
+	}
+	start := bytes.LastIndexByte(data[:pos], 0) + 1
+	end := bytes.IndexByte(data[pos:], 0)
+	if end == -1 {
+		return data[start:]
+	}
+	return data[start : pos+end]
+}
+
+// versionAt returns the version that re matches at the start of data, or nil
+func versionAt(data []byte, re *regexp.Regexp) []byte {
+	if m := re.FindIndex(data); m != nil && m[0] == 0 {
+		return data[:m[1]]
+	}
+	return nil
+}
+
+// ghcCompiler detects the Glasgow Haskell Compiler, from the .comment section
+func ghcCompiler(f *File) (*Info, error) {
+	data := f.SectionData(".comment")
+	if !bytes.Contains(data, []byte(ghcMarker)) {
+		return nil, nil
+	}
+	ghcVersion := bytes.TrimSpace(ainur.GHCVersionRegex.Find(data))
+	if len(ghcVersion) == 0 {
+		return nil, nil
+	}
+	return NewInfo("GHC", string(ghcVersion[4:]), ".comment", commentEntry(data, ghcVersion), ConfidenceHigh), nil
+}
+
+// gccCompiler detects GCC or Clang, from the .comment section.
+// If the section does not mention GCC, the first producer string is used.
+func gccCompiler(f *File) (*Info, error) {
+	versionData := f.SectionData(".comment")
+	if versionData == nil {
+		return nil, nil
+	}
+	data := versionData
+	if !bytes.Contains(versionData, []byte(gccMarker)) {
+		// Use the first producer string
+		if entries := Producers(f.File); len(entries) > 0 {
+			return NewInfo(entries[0].Name, entries[0].Version, ".comment", []byte(entries[0].Entry), ConfidenceMedium), nil
+		}
+		return nil, nil
+	}
+	// Check if this is really clang
+	if bytes.Contains(versionData, []byte(clangMarker)) {
+		clangVersion := bytes.TrimSpace(ainur.GCCVersionRegex0.Find(versionData))
+		return NewInfo("Clang", string(clangVersion), ".comment", commentEntry(data, []byte(clangMarker)), ConfidenceHigh), nil
+	}
+	// If the bytes are on this form: "GCC: (GNU) 6.3.0GCC: (GNU) 7.2.0",
+	// use the largest version number.
+	if bytes.Count(versionData, []byte(gccMarker)) > 1 {
+		// Split in to 3 parts, always valid for >=2 instances of gccMarker
+		elements := bytes.SplitN(versionData, []byte(gccMarker), 3)
+		versionA := bytes.TrimPrefix(elements[1], []byte(gnuEnding))
+		versionB := bytes.TrimPrefix(elements[2], []byte(gnuEnding))
+		if ainur.FirstIsGreater(string(versionA), string(versionB)) {
+			versionData = versionA
+		} else {
+			versionData = versionB
+		}
+	}
+	gcc := func(version []byte) *Info {
+		return NewInfo("GCC", string(version), ".comment", commentEntry(data, version), ConfidenceHigh)
+	}
+	// Try the first regexp for picking out the version
+	if gccVersion := bytes.TrimSpace(ainur.GCCVersionRegex1.Find(versionData)); len(gccVersion) > 0 {
+		return gcc(gccVersion[2:]), nil
+	}
+	// Try the second and third regexp, but check that the version
+	// does not start with "1.", that may happen
+	for _, re := range []*regexp.Regexp{ainur.GCCVersionRegex2, ainur.GCCVersionRegex3} {
+		if gccVersion := bytes.TrimSpace(re.Find(versionData)); len(gccVersion) > 0 && !bytes.HasPrefix(gccVersion, []byte("1.")) {
+			return gcc(gccVersion), nil
+		}
+	}
+	// Try the fourth regexp for picking out the version
+	if gccVersion := bytes.TrimSpace(ainur.GCCVersionRegex4.Find(versionData)); len(gccVersion) > 0 {
+		return gcc(gccVersion[2:]), nil
+	}
+	// Failed to find a GCC version string
+	return nil, nil
+}
+
+// rustCompilerUnstripped detects the Rust compiler and version, from the
+// debug information in unstripped executables
+func rustCompilerUnstripped(f *File) (*Info, error) {
+	offsets, err := f.Find(".debug_str", rustMarker)
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range offsets {
+		data := f.Window(".debug_str", pos, versionWindow)
+		start := len(rustMarker) + 1
+		if start > len(data) {
+			continue
+		}
+		end := bytes.IndexByte(data[start:], '(')
+		if end == -1 {
+			continue
+		}
+		versionString := strings.TrimSpace(string(data[start : start+end]))
+		return NewInfo("Rust", versionString, ".debug_str", data[:start+end], ConfidenceHigh), nil
+	}
+	return nil, nil
+}
+
+// rustCompilerStripped detects the Rust compiler from a stripped
+// executable, which does not contain the Rust version number. Rust may
+// use GCC for linking, which is then reported as the linker.
+func rustCompilerStripped(f *File) (*Info, error) {
+	// Check if the .gcc_except_table ELF section exists
+	if f.Section(".gcc_except_table") == nil {
+		return nil, nil
+	}
+	rust := func(evidence []byte) (*Info, error) {
+		info := NewInfo("Rust", "", ".rodata", evidence, ConfidenceLow)
+		linker, err := gccCompiler(f)
+		if err != nil {
+			return nil, err
+		}
+		if linker != nil {
+			info.Linker = linker.String()
+		}
+		return info, nil
+	}
+	// Look for the rust marker that may appear in new, stripped executables
+	offsets, err := f.Find(".rodata", rustcPathMarker)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) > 0 {
+		evidence := f.Window(".rodata", offsets[0], versionWindow)
+		if end := bytes.IndexByte(evidence, 0); end != -1 {
+			evidence = evidence[:end]
+		}
+		return rust(evidence)
+	}
+	// Look for the rust marker that may appear in old, stripped executables,
+	// after the NUL that terminates the previous string
+	if offsets, err = f.Find(".rodata", rustSymbolMarker); err != nil {
+		return nil, err
+	}
+	for _, pos := range offsets {
+		if before := f.Window(".rodata", pos-1, 1); len(before) == 1 && before[0] == 0 {
+			return rust([]byte(rustSymbolMarker))
+		}
+	}
+	return nil, nil
+}
+
+// goCompiler detects the Go compiler and version, from the build info.
+// For old Go executables without build info, .rodata is searched instead.
+func goCompiler(f *File) (*Info, error) {
+	if info, err := ReadGoBuildInfo(f.File); err == nil {
+		// The version may be on the form "devel go1.22-abcdef" or "go1.21.0 X:boringcrypto"
+		if fields := strings.Fields(strings.TrimPrefix(info.GoVersion, "devel ")); len(fields) > 0 {
+			return NewInfo("Go", strings.TrimPrefix(fields[0], "go"), ".go.buildinfo", []byte(info.GoVersion), ConfidenceHigh), nil
+		}
+	}
+	offsets, err := f.Find(".rodata", goMarker)
+	if err != nil {
+		return nil, err
+	}
+	for _, pos := range offsets {
+		if goVersion := versionAt(f.Window(".rodata", pos, versionWindow), ainur.GoVersionRegex); goVersion != nil {
+			return NewInfo("Go", string(goVersion[2:]), ".rodata", goVersion, ConfidenceMedium), nil
+		}
+	}
+	return nil, nil
+}
+
+// tccCompiler detects TCC, which has no version number, but does have
+// some signature sections
+func tccCompiler(f *File) (*Info, error) {
+	// TCC does not normally have the .note.ABI-tag section,
+	// but usually has the .rodata.cst4 section
+	if f.Section(".note.ABI-tag") != nil || f.Section(".rodata.cst4") == nil {
+		return nil, nil
+	}
+	return NewInfo("TCC", "", ".rodata.cst4", nil, ConfidenceLow), nil
+}
+
+// ocamlCompiler detects the OCaml compiler and version, from the .rodata
+// section. The version is searched for around the marker.
+func ocamlCompiler(f *File) (*Info, error) {
+	offsets, err := f.Find(".rodata", ocamlMarker)
+	if err != nil || len(offsets) == 0 {
+		return nil, err
+	}
+	ocamlVersion := ainur.OcamlVersionRegex.Find(f.Window(".rodata", offsets[0]-versionWindow, 2*versionWindow))
+	return NewInfo("OCaml", string(ocamlVersion), ".rodata", ocamlVersion, ConfidenceMedium), nil
+}
@@ -0,0 +1,30 @@
+package compiler
+
+import (
+	"debug/elf"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompiler(t *testing.T) {
+	f, err := elf.Open(os.Args[0])
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	want := "Go " + strings.TrimPrefix(runtime.Version(), "go")
+	if got := Compiler(f); got != want {
+		t.Errorf("got %q for the test binary, want %q", got, want)
+	}
+	ef := NewFile(f, nil)
+	defer ef.Close()
+	info, err := Detect(ef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.String(); got != want {
+		t.Errorf("Detect found %q for the test binary, want %q", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package compiler
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+)
+
+// File is an ELF file that is examined by the compiler detectors. Each
+// section is read at most once, and each byte pattern is searched for at
+// most once in each section. If the file can be memory mapped, the sections are sliced from the mapping.
+// Otherwise, the sections are streamed through when searching, and only
+// the data around the matches is read afterwards.
+type File struct {
+	*elf.File
+	mapped   []byte                        // the whole file, if it is memory mapped
+	sections map[string][]byte             // the sections that have been read, nil if missing
+	errs     map[string]error              // the errors from reading the sections
+	matches  map[string]map[string][]int64 // the file offsets of the patterns in the sections that have been searched
+}
+
+// NewFile prepares f for the compiler detectors. If r is a file, it is
+// memory mapped, and Close must be called when the detectors are done.
+func NewFile(f *elf.File, r io.ReaderAt) *File {
+	e := &File{File: f, sections: make(map[string][]byte), errs: make(map[string]error), matches: make(map[string]map[string][]int64)}
+	if file, ok := r.(*os.File); ok {
+		if fi, err := file.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 && int64(int(fi.Size())) == fi.Size() {
+			if mapped, err := mapFile(file, fi.Size()); err == nil {
+				e.mapped = mapped
+			}
+		}
+	}
+	return e
+}
+
+// Close unmaps the file, if it was memory mapped. The section data must not
+// be used after this. The underlying elf.File is not closed.
+func (e *File) Close() {
+	if e.mapped != nil {
+		unmapFile(e.mapped)
+		e.mapped, e.sections = nil, nil
+	}
+}
+
+// inMemory checks if the data of a section is available without reading
+// the whole section, which is not the case for compressed sections
+func (e *File) inMemory(sec *elf.Section) bool {
+	_, loaded := e.sections[sec.Name]
+	return loaded || e.mapped != nil || sec.Flags&elf.SHF_COMPRESSED != 0
+}
+
+// SectionData returns the contents of the given section, or nil. Sections that
+// are not compressed are sliced from the memory mapping, if there is one,
+// so the data must not be modified.
+func (e *File) SectionData(name string) []byte {
+	data, _ := e.readSection(name)
+	return data
+}
+
+// readSection returns the contents of the given section, like SectionData,
+// together with the error if the section could not be read
+func (e *File) readSection(name string) ([]byte, error) {
+	if data, ok := e.sections[name]; ok {
+		return data, e.errs[name]
+	}
+	var data []byte
+	var err error
+	if sec := e.Section(name); sec != nil && sec.Type != elf.SHT_NOBITS {
+		end := sec.Offset + sec.FileSize
+		if e.mapped != nil && sec.Flags&elf.SHF_COMPRESSED == 0 && end >= sec.Offset && end <= uint64(len(e.mapped)) {
+			data = e.mapped[sec.Offset:end]
+		} else if data, err = sec.Data(); err != nil {
+			data = nil
+			err = fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	e.sections[name], e.errs[name] = data, err
+	return data, err
+}
+
+// Window returns up to size bytes from the given file offset in the given
+// section, like the offsets returned by Find. The data is only read from
+// the file if the section is not in memory. Offsets before the start of the
+// section are moved to the start.
+func (e *File) Window(name string, offset int64, size int) []byte {
+	sec := e.Section(name)
+	if sec == nil || sec.Type == elf.SHT_NOBITS {
+		return nil
+	}
+	// The offset in the section
+	pos := offset - int64(sec.Offset)
+	if pos < 0 {
+		if -pos >= int64(size) {
+			return nil
+		}
+		size += int(pos)
+		pos = 0
+	}
+	if size <= 0 {
+		return nil
+	}
+	if e.inMemory(sec) {
+		data := e.SectionData(name)
+		if pos >= int64(len(data)) {
+			return nil
+		}
+		if end := pos + int64(size); end < int64(len(data)) {
+			return data[pos:end]
+		}
+		return data[pos:]
+	}
+	buf := make([]byte, size)
+	n, err := sec.ReadAt(buf, pos)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+	return buf[:n]
+}
+
+// Find returns the file offsets of the given pattern in the given section,
+// and an error if the section could not be read. For compressed sections,
+// the offsets are in the uncompressed data, counted from the start of the
+// section in the file. Each pattern is searched for the first time it is
+// asked for, in the section data. Sections that are not in memory are
+// streamed through once instead, and all the detector patterns for that
+// section are searched for at once.
+func (e *File) Find(section, pattern string) ([]int64, error) {
+	sec := e.Section(section)
+	if sec == nil || sec.Type == elf.SHT_NOBITS {
+		return nil, nil
+	}
+	found, ok := e.matches[section]
+	if !ok {
+		found = make(map[string][]int64)
+		e.matches[section] = found
+		if m, ok := sectionMatchers[section]; ok && !e.inMemory(sec) {
+			// Patterns that are not found are recorded too
+			for _, p := range m.patterns {
+				found[p] = nil
+			}
+			err := m.scanReader(sec.Open(), int64(sec.Offset), func(p int, offset int64) bool {
+				found[m.patterns[p]] = append(found[m.patterns[p]], offset)
+				return true
+			})
+			if err != nil {
+				// Search again the next time
+				delete(e.matches, section)
+				return nil, fmt.Errorf("%s: %v", section, err)
+			}
+		}
+	}
+	if offsets, ok := found[pattern]; ok {
+		return offsets, nil
+	}
+	data, err := e.readSection(section)
+	if err != nil {
+		return nil, err
+	}
+	var offsets []int64
+	newMatcher([]string{pattern}).scan(data, int64(sec.Offset), func(_ int, offset int64) bool {
+		offsets = append(offsets, offset)
+		return true
+	})
+	found[pattern] = offsets
+	return offsets, nil
+}
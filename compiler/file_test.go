@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// openTestBinary opens the test binary, which is a Go executable
+func openTestBinary(t *testing.T) (*os.File, *elf.File) {
+	file, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Skip(err)
+	}
+	f, err := elf.NewFile(file)
+	if err != nil {
+		file.Close()
+		t.Skip(err)
+	}
+	return file, f
+}
+
+func TestFind(t *testing.T) {
+	file, f := openTestBinary(t)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := f.Section(".rodata")
+	if sec == nil {
+		t.Skip("no .rodata section")
+	}
+	mapped := NewFile(f, file)
+	defer mapped.Close()
+	// Without a file to map, the markers are streamed through
+	streamed := NewFile(f, nil)
+	for _, pattern := range []string{goMarker, rustcPathMarker, "runtime.", "not in the test binary \x00\xff"} {
+		offsets, err := mapped.Find(".rodata", pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, err := streamed.Find(".rodata", pattern); err != nil || !reflect.DeepEqual(other, offsets) {
+			t.Errorf("%q: found %d offsets and error %v when streaming, want %d", pattern, len(other), err, len(offsets))
+		}
+		if want := bytes.Count(data[sec.Offset:sec.Offset+sec.Size], []byte(pattern)); len(offsets) != want {
+			t.Errorf("%q: found %d offsets, want %d", pattern, len(offsets), want)
+		}
+		for _, offset := range offsets {
+			if offset < int64(sec.Offset) || offset >= int64(sec.Offset+sec.Size) {
+				t.Errorf("%q: offset %#x is outside of .rodata", pattern, offset)
+			} else if got := string(data[offset : offset+int64(len(pattern))]); got != pattern {
+				t.Errorf("%q: found %q at offset %#x in the file", pattern, got, offset)
+			}
+			if got := string(streamed.Window(".rodata", offset, len(pattern))); got != pattern {
+				t.Errorf("%q: the window at offset %#x is %q", pattern, offset, got)
+			}
+		}
+	}
+	if offsets, _ := mapped.Find(".rodata", goMarker); len(offsets) == 0 {
+		t.Errorf("%q was not found in the test binary", goMarker)
+	}
+}
+
+// failingReaderAt returns an error for reads that overlap the given range
+type failingReaderAt struct {
+	r          io.ReaderAt
+	start, end int64
+}
+
+var errRead = errors.New("read error")
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < f.end && off+int64(len(p)) > f.start {
+		return 0, errRead
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestFindError(t *testing.T) {
+	file, f := openTestBinary(t)
+	defer file.Close()
+	sec := f.Section(".rodata")
+	if sec == nil {
+		t.Skip("no .rodata section")
+	}
+	r := &failingReaderAt{file, int64(sec.Offset), int64(sec.Offset + sec.Size)}
+	f, err := elf.NewFile(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef := NewFile(f, r)
+	for _, pattern := range []string{goMarker, "runtime."} {
+		if offsets, err := ef.Find(".rodata", pattern); err == nil || err.Error() != ".rodata: "+errRead.Error() {
+			t.Errorf("%q: found %d offsets and error %v, want a read error", pattern, len(offsets), err)
+		}
+	}
+	if _, err := DetectAll(ef); err == nil {
+		t.Error("DetectAll did not return the read error")
+	}
+}
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"bytes"
+	"io"
+)
+
+// matcher finds all the occurrences of a set of byte patterns, with one
+// bytes.Index search per pattern. On the sections of real binaries, this is
+// faster than a single pass over the data for all the patterns, since
+// bytes.Index skips ahead with vector instructions. See BenchmarkSectionSearch.
+type matcher struct {
+	patterns []string
+	maxLen   int // the length of the longest pattern
+}
+
+// newMatcher creates a matcher for the given patterns. Empty patterns are
+// never matched.
+func newMatcher(patterns []string) *matcher {
+	m := &matcher{patterns: patterns}
+	for _, pattern := range patterns {
+		if len(pattern) > m.maxLen {
+			m.maxLen = len(pattern)
+		}
+	}
+	return m
+}
+
+// scan calls fn with the index of the pattern and the offset of every
+// occurrence, pattern by pattern, in the order of the offsets. The offsets
+// are counted from base, which is the offset of data, for example in a
+// file. The scan stops if fn returns false.
+func (m *matcher) scan(data []byte, base int64, fn func(pattern int, offset int64) bool) {
+	m.scanFrom(data, 0, base, fn)
+}
+
+// scanReader is like scan, but reads the data from r, in chunks. The end of
+// each chunk is kept and searched again together with the next chunk, so
+// that occurrences that span two chunks are found too. Each occurrence is
+// reported exactly once, with the offset from the start of r plus base.
+func (m *matcher) scanReader(r io.Reader, base int64, fn func(pattern int, offset int64) bool) error {
+	if m.maxLen == 0 {
+		return nil
+	}
+	keep := m.maxLen - 1
+	buf := make([]byte, keep+BufferSize)
+	// The number of bytes kept at the start of buf. base is the offset of buf.
+	kept := 0
+	for {
+		n, err := r.Read(buf[kept:])
+		if n > 0 {
+			if !m.scanFrom(buf[:kept+n], kept, base, fn) {
+				return nil
+			}
+			// Keep the end of the data, for occurrences that continue in the next chunk
+			end := kept + n
+			start := end - keep
+			if start < 0 {
+				start = 0
+			}
+			kept = copy(buf, buf[start:end])
+			base += int64(start)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanFrom scans data, which is at the given offset in the stream.
+// Occurrences that end within the first skip bytes of data have already been
+// reported, and are skipped. False is returned if fn stopped the scan.
+func (m *matcher) scanFrom(data []byte, skip int, base int64, fn func(pattern int, offset int64) bool) bool {
+	for p, pattern := range m.patterns {
+		if pattern == "" {
+			continue
+		}
+		// The first position where an occurrence would end after skip
+		start := skip - len(pattern) + 1
+		if start < 0 {
+			start = 0
+		}
+		b := []byte(pattern)
+		for start <= len(data)-len(b) {
+			pos := bytes.Index(data[start:], b)
+			if pos == -1 {
+				break
+			}
+			start += pos
+			if !fn(p, base+int64(start)) {
+				return false
+			}
+			start++
+		}
+	}
+	return true
+}
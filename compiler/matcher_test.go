@@ -0,0 +1,229 @@
+package compiler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/iotest"
+)
+
+// occurrence is a pattern index and the offset it was found at
+type occurrence struct {
+	pattern int
+	offset  int64
+}
+
+// sortOccurrences sorts occurrences by offset, and then by pattern
+func sortOccurrences(found []occurrence) {
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].offset != found[j].offset {
+			return found[i].offset < found[j].offset
+		}
+		return found[i].pattern < found[j].pattern
+	})
+}
+
+// indexAll finds every occurrence of every pattern with one bytes.Index
+// search per pattern, over the whole data at once
+func indexAll(data []byte, patterns []string) []occurrence {
+	var found []occurrence
+	for i, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		for offset := 0; ; offset++ {
+			pos := bytes.Index(data[offset:], []byte(pattern))
+			if pos == -1 {
+				break
+			}
+			offset += pos
+			found = append(found, occurrence{i, int64(offset)})
+		}
+	}
+	sortOccurrences(found)
+	return found
+}
+
+// scanAll finds every occurrence with the matcher
+func scanAll(data []byte, patterns []string) []occurrence {
+	var found []occurrence
+	newMatcher(patterns).scan(data, 0, func(pattern int, offset int64) bool {
+		found = append(found, occurrence{pattern, offset})
+		return true
+	})
+	sortOccurrences(found)
+	return found
+}
+
+// markerPatterns returns the patterns of the built-in detectors
+func markerPatterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, d := range detectors {
+		for _, m := range d.Markers {
+			if !seen[m.Pattern] {
+				seen[m.Pattern] = true
+				patterns = append(patterns, m.Pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// randomData returns size random bytes from the given alphabet, with the
+// patterns inserted at every interval bytes
+func randomData(seed int64, size int, alphabet string, patterns []string, interval int) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = alphabet[rnd.Intn(len(alphabet))]
+	}
+	if len(patterns) > 0 && interval > 0 {
+		for pos := rnd.Intn(interval); pos < size; pos += interval {
+			copy(data[pos:], patterns[rnd.Intn(len(patterns))])
+		}
+	}
+	return data
+}
+
+// allBytes is an alphabet of every byte value
+var allBytes = func() string {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return string(b)
+}()
+
+func TestMatcherMatchesIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		data     []byte
+	}{
+		{"no patterns", nil, []byte("GCC: (GNU) 12.2.0")},
+		{"empty pattern", []string{"", "GCC"}, []byte("GCC: (GNU) 12.2.0, GCC")},
+		{"empty data", []string{"GCC"}, nil},
+		{"markers", markerPatterns(), randomData(1, 1<<16, allBytes, markerPatterns(), 300)},
+		{"markers in text", markerPatterns(), randomData(2, 1<<16, "abcdefghijklmnopqrstuvwxyz _-.:(/[", markerPatterns(), 100)},
+		{"overlapping", []string{"a", "aa", "aab", "ab", "b", "bab", "abab"}, randomData(3, 4096, "ab", nil, 0)},
+		{"duplicates", []string{"go1.", "go1.", "o1"}, randomData(4, 4096, "go1.", nil, 0)},
+		{"many first bytes", []string{"0a", "1b", "2c", "3d", "4e", "5f", "6g", "7h", "8i", "9j"}, randomData(5, 4096, "0123456789abcdefghij", nil, 0)},
+	}
+	for _, test := range tests {
+		want := indexAll(test.data, test.patterns)
+		if got := scanAll(test.data, test.patterns); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: found %d occurrences, want %d", test.name, len(got), len(want))
+		}
+	}
+}
+
+// errReader returns the data from r, and then err instead of io.EOF
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		err = e.err
+	}
+	return n, err
+}
+
+func TestScanReader(t *testing.T) {
+	patterns := markerPatterns()
+	// Put each marker across a chunk boundary of scanReader, at every
+	// position from ending right at the boundary to starting right at it
+	var planted []occurrence
+	for i, pattern := range patterns {
+		for split := 0; split <= len(pattern); split++ {
+			boundary := int64(len(planted)+1) * BufferSize
+			planted = append(planted, occurrence{i, boundary - int64(split)})
+		}
+	}
+	data := randomData(7, (len(planted)+1)*BufferSize, "xyz", nil, 0)
+	for _, o := range planted {
+		copy(data[o.offset:], patterns[o.pattern])
+	}
+	if found := scanAll(data, patterns); !reflect.DeepEqual(found, planted) {
+		t.Fatalf("the matcher found %v in the test data, want %v", found, planted)
+	}
+	// The data is reported at an offset in a file, like a section
+	const base = 0x12345
+	var want []occurrence
+	for _, o := range planted {
+		want = append(want, occurrence{o.pattern, base + o.offset})
+	}
+
+	tests := []struct {
+		name string
+		r    func() io.Reader
+	}{
+		{"full reads", func() io.Reader { return bytes.NewReader(data) }},
+		{"half reads", func() io.Reader { return iotest.HalfReader(bytes.NewReader(data)) }},
+		{"one byte reads", func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) }},
+		{"data with EOF", func() io.Reader { return iotest.DataErrReader(bytes.NewReader(data)) }},
+		{"half reads with EOF", func() io.Reader { return iotest.DataErrReader(iotest.HalfReader(bytes.NewReader(data))) }},
+	}
+	m := newMatcher(patterns)
+	for _, test := range tests {
+		var got []occurrence
+		err := m.scanReader(test.r(), base, func(pattern int, offset int64) bool {
+			got = append(got, occurrence{pattern, offset})
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		sortOccurrences(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: found %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+func TestScanReaderStop(t *testing.T) {
+	data := randomData(9, 4*BufferSize, "xyz", []string{gccMarker}, BufferSize/3)
+	calls := 0
+	err := newMatcher([]string{gccMarker}).scanReader(iotest.HalfReader(bytes.NewReader(data)), 0, func(int, int64) bool {
+		calls++
+		return false
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("got %d calls and error %v, want 1 call and no error", calls, err)
+	}
+}
+
+func TestScanReaderError(t *testing.T) {
+	readErr := errors.New("read error")
+	r := &errReader{bytes.NewReader(randomData(10, 2*BufferSize, "xyz", nil, 0)), readErr}
+	if err := newMatcher([]string{gccMarker}).scanReader(r, 0, func(int, int64) bool { return true }); err != readErr {
+		t.Errorf("got error %v, want %v", err, readErr)
+	}
+}
+
+// benchmarkData is .rodata-like data, with a marker every 64 KiB
+var benchmarkData = randomData(6, 8<<20, allBytes, markerPatterns(), 64<<10)
+
+func BenchmarkMatcher(b *testing.B) {
+	m := newMatcher(markerPatterns())
+	b.SetBytes(int64(len(benchmarkData)))
+	for i := 0; i < b.N; i++ {
+		m.scan(benchmarkData, 0, func(int, int64) bool { return true })
+	}
+}
+
+func BenchmarkScanReader(b *testing.B) {
+	m := newMatcher(markerPatterns())
+	b.SetBytes(int64(len(benchmarkData)))
+	for i := 0; i < b.N; i++ {
+		m.scanReader(bytes.NewReader(benchmarkData), 0, func(int, int64) bool { return true })
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package compiler
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile maps the first size bytes of the given file into memory, read only
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported")
+}
+
+// unmapFile unmaps memory that was mapped with mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
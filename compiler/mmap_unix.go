@@ -0,0 +1,19 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package compiler
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of the given file into memory, read only
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile unmaps memory that was mapped with mapFile
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,164 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Marker is a byte pattern that a detector searches for in a section
+type Marker struct {
+	Section string
+	Pattern string
+}
+
+// Detector is a compiler detector in the registry. Detectors for
+// signatures are created with Signature.Detector.
+type Detector struct {
+	Name     string                       // the compiler family, like "GCC"
+	Priority int                          // detectors with a higher priority are tried first
+	Markers  []Marker                     // the patterns the detector searches for with File.Find
+	Detect   func(f *File) (*Info, error) // returns nil if the compiler is not detected, or the error from File.Find
+	describe string                       // describes a signature, for telling detector sets apart
+}
+
+// detectors is the registry of compiler detectors, ordered from the more
+// specific to the more ambiguous ones, by priority
+var detectors []*Detector
+
+// sectionMatchers has a matcher for the markers of the registered
+// detectors, for each section
+var sectionMatchers = make(map[string]*matcher)
+
+// Register adds a detector to the registry. Detectors with the same
+// priority are tried in the order they were registered. All detectors must
+// be registered before any file is examined, for example in an init function.
+// Register panics if the detector has no Detect function.
+func Register(d *Detector) {
+	if d == nil || d.Detect == nil {
+		panic("compiler: Register of a detector without a Detect function")
+	}
+	detectors = append(detectors, d)
+	sort.SliceStable(detectors, func(i, j int) bool {
+		return detectors[i].Priority > detectors[j].Priority
+	})
+	// Rebuild the matchers for the sections the detector searches
+	for _, m := range d.Markers {
+		var patterns []string
+		seen := make(map[string]bool)
+		for _, other := range detectors {
+			for _, om := range other.Markers {
+				if om.Section == m.Section && !seen[om.Pattern] {
+					seen[om.Pattern] = true
+					patterns = append(patterns, om.Pattern)
+				}
+			}
+		}
+		sectionMatchers[m.Section] = newMatcher(patterns)
+	}
+}
+
+// Version is the version of the built-in detectors. It must be increased
+// whenever a detector is changed in a way that can change the results.
+const Version = 2
+
+// Fingerprint describes the version of the built-in detectors and every
+// registered detector, including the signatures, so that results from other
+// sets of detectors can be told apart, for example in a cache
+func Fingerprint() string {
+	descriptions := []string{fmt.Sprintf("version %d", Version)}
+	for _, d := range detectors {
+		if d.describe != "" {
+			descriptions = append(descriptions, d.describe)
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("%s %d %q", d.Name, d.Priority, d.Markers))
+		}
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// Signature is a declarative compiler detector: a regular expression that
+// is matched with the data in a section. The version is the text matched
+// by the "version" group of the pattern, if it has one. If a marker is
+// given, every match must start with it, and the section is searched for
+// the marker together with the markers of the other detectors.
+type Signature struct {
+	Name       string
+	Section    string
+	Pattern    string
+	Marker     string
+	Priority   int
+	Confidence string // high, medium or low. The default is medium.
+}
+
+// Detector compiles a signature into a detector
+func (s Signature) Detector() (*Detector, error) {
+	if s.Name == "" || s.Section == "" || s.Pattern == "" {
+		return nil, errors.New("a signature needs a name, section and pattern")
+	}
+	re, err := regexp.Compile(s.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	versionGroup := -1
+	for i, name := range re.SubexpNames() {
+		if name == "version" {
+			versionGroup = i
+		}
+	}
+	switch s.Confidence {
+	case "":
+		s.Confidence = ConfidenceMedium
+	case ConfidenceHigh, ConfidenceMedium, ConfidenceLow:
+	default:
+		return nil, fmt.Errorf("unknown confidence: %s", s.Confidence)
+	}
+	found := func(data []byte, m []int) *Info {
+		version := ""
+		if versionGroup != -1 && m[2*versionGroup] != -1 {
+			version = string(data[m[2*versionGroup]:m[2*versionGroup+1]])
+		}
+		return NewInfo(s.Name, version, s.Section, data[m[0]:m[1]], s.Confidence)
+	}
+	d := &Detector{
+		Name:     s.Name,
+		Priority: s.Priority,
+		describe: fmt.Sprintf("%s %d %s %q %q %s", s.Name, s.Priority, s.Section, s.Marker, s.Pattern, s.Confidence),
+	}
+	if s.Marker == "" {
+		d.Detect = func(f *File) (*Info, error) {
+			data := f.SectionData(s.Section)
+			if m := re.FindSubmatchIndex(data); m != nil {
+				return found(data, m), nil
+			}
+			return nil, nil
+		}
+		return d, nil
+	}
+	d.Markers = []Marker{{s.Section, s.Marker}}
+	d.Detect = func(f *File) (*Info, error) {
+		offsets, err := f.Find(s.Section, s.Marker)
+		if err != nil {
+			return nil, err
+		}
+		for _, pos := range offsets {
+			data := f.Window(s.Section, pos, versionWindow)
+			if m := re.FindSubmatchIndex(data); m != nil && m[0] == 0 {
+				return found(data, m), nil
+			}
+		}
+		return nil, nil
+	}
+	return d, nil
+}
+
+// mustDetector compiles a built-in signature into a detector
+func mustDetector(s Signature) *Detector {
+	d, err := s.Detector()
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
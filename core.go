@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Note types in core files, which have the note name "CORE" or "LINUX"
+const (
+	ntPrStatus = 1          // NT_PRSTATUS, one per thread
+	ntPrPsInfo = 3          // NT_PRPSINFO
+	ntAuxv     = 6          // NT_AUXV
+	ntSigInfo  = 0x53494749 // NT_SIGINFO, "SIGI"
+	ntFile     = 0x46494c45 // NT_FILE, "FILE"
+)
+
+// coreInfo is what could be found out about the crashed process in a core file
+type coreInfo struct {
+	Program string       `json:"program"`          // pr_fname from NT_PRPSINFO, truncated to 15 characters by the kernel
+	Args    string       `json:"args"`             // pr_psargs from NT_PRPSINFO, truncated to 80 characters by the kernel
+	ExecFn  string       `json:"execfn,omitempty"` // the path the program was started with, from the AT_EXECFN auxv entry
+	PID     int          `json:"pid"`
+	PPID    int          `json:"ppid"`
+	UID     int          `json:"uid"`
+	GID     int          `json:"gid"`
+	State   string       `json:"state,omitempty"` // like "R" for running
+	Signal  *coreSignal  `json:"signal,omitempty"`
+	Threads []coreThread `json:"threads"`
+	Files   []mappedFile `json:"files"`
+	Auxv    []auxvEntry  `json:"auxv,omitempty"`
+}
+
+// coreSignal is the signal that caused the core dump, from NT_SIGINFO
+type coreSignal struct {
+	Number    int    `json:"number"`
+	Name      string `json:"name"`
+	Code      int    `json:"code"`
+	CodeName  string `json:"code_name,omitempty"`
+	Errno     int    `json:"errno,omitempty"`
+	Addr      string `json:"addr,omitempty"`       // the faulting address, for SIGSEGV, SIGBUS, SIGILL, SIGFPE and SIGTRAP
+	SenderPID int    `json:"sender_pid,omitempty"` // the process that sent the signal, if it was sent by a process
+}
+
+// coreThread is a thread in the crashed process, from NT_PRSTATUS. The
+// first thread is the one that received the signal.
+type coreThread struct {
+	TID    int    `json:"tid"`
+	Signal string `json:"signal,omitempty"` // the pending signal, pr_cursig
+	PC     string `json:"pc,omitempty"`     // the program counter, for the machines that are known
+	SP     string `json:"sp,omitempty"`     // the stack pointer, for the machines that are known
+}
+
+// mappedFile is a file that was mapped into the crashed process, from NT_FILE
+type mappedFile struct {
+	Path    string `json:"path"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Offset  uint64 `json:"offset"`             // the file offset, in bytes
+	BuildID string `json:"build_id,omitempty"` // if the ELF headers of the file were included in the core dump
+
+	start uint64
+}
+
+// auxvEntry is an entry in the auxiliary vector, from NT_AUXV
+type auxvEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"` // hex, or the string that is pointed to, for AT_EXECFN and AT_PLATFORM
+}
+
+// signalNames are the Linux signal names, as numbered on most architectures
+var signalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT",
+	7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2",
+	13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM", 16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT",
+	19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU", 23: "SIGURG", 24: "SIGXCPU",
+	25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO", 30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// signalName returns the name of a signal, like "SIGSEGV"
+func signalName(signo int) string {
+	if name, ok := signalNames[signo]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", signo)
+}
+
+// signalCodeName returns the name of a si_code value, or an empty string
+func signalCodeName(signo, code int) string {
+	switch code {
+	case 0:
+		return "SI_USER"
+	case 0x80:
+		return "SI_KERNEL"
+	case -1:
+		return "SI_QUEUE"
+	case -6:
+		return "SI_TKILL"
+	}
+	codes := map[int][]string{
+		4:  {"ILL_ILLOPC", "ILL_ILLOPN", "ILL_ILLADR", "ILL_ILLTRP", "ILL_PRVOPC", "ILL_PRVREG", "ILL_COPROC", "ILL_BADSTK"},
+		7:  {"BUS_ADRALN", "BUS_ADRERR", "BUS_OBJERR", "BUS_MCEERR_AR", "BUS_MCEERR_AO"},
+		8:  {"FPE_INTDIV", "FPE_INTOVF", "FPE_FLTDIV", "FPE_FLTOVF", "FPE_FLTUND", "FPE_FLTRES", "FPE_FLTINV", "FPE_FLTSUB"},
+		11: {"SEGV_MAPERR", "SEGV_ACCERR", "SEGV_BNDERR", "SEGV_PKUERR"},
+	}[signo]
+	if code >= 1 && code <= len(codes) {
+		return codes[code-1]
+	}
+	return ""
+}
+
+// auxvNames are the names of the auxiliary vector entries that are reported
+var auxvNames = map[uint64]string{
+	3: "AT_PHDR", 4: "AT_PHENT", 5: "AT_PHNUM", 6: "AT_PAGESZ", 7: "AT_BASE", 8: "AT_FLAGS",
+	9: "AT_ENTRY", 11: "AT_UID", 12: "AT_EUID", 13: "AT_GID", 14: "AT_EGID", 15: "AT_PLATFORM",
+	16: "AT_HWCAP", 17: "AT_CLKTCK", 23: "AT_SECURE", 24: "AT_BASE_PLATFORM", 25: "AT_RANDOM",
+	26: "AT_HWCAP2", 31: "AT_EXECFN", 33: "AT_SYSINFO_EHDR", 51: "AT_MINSIGSTKSZ",
+}
+
+// Auxiliary vector entries that point to strings
+const (
+	atPlatform = 15
+	atExecFn   = 31
+)
+
+// coreReader decodes the machine dependent structures in a core file
+type coreReader struct {
+	f        *elf.File
+	wordSize int // the size of a long, in bytes
+}
+
+// word returns the long at the given offset in data, or 0
+func (c *coreReader) word(data []byte, offset int) uint64 {
+	if offset < 0 || offset+c.wordSize > len(data) {
+		return 0
+	}
+	if c.wordSize == 8 {
+		return c.f.ByteOrder.Uint64(data[offset:])
+	}
+	return uint64(c.f.ByteOrder.Uint32(data[offset:]))
+}
+
+// int32At returns the int at the given offset in data, or 0
+func (c *coreReader) int32At(data []byte, offset int) int {
+	if offset < 0 || offset+4 > len(data) {
+		return 0
+	}
+	return int(int32(c.f.ByteOrder.Uint32(data[offset:])))
+}
+
+// cString returns the NUL terminated string at the start of data
+func cString(data []byte) string {
+	if end := bytes.IndexByte(data, 0); end != -1 {
+		data = data[:end]
+	}
+	return string(data)
+}
+
+// hexAddr formats an address as hex
+func hexAddr(addr uint64) string {
+	return fmt.Sprintf("0x%x", addr)
+}
+
+// readMemory reads up to size bytes of the memory of the crashed process,
+// at the given address, from the PT_LOAD segments that have data in the core
+// file. Sizes are read from the core file, so at most maxMetadataSize bytes
+// are read.
+func (c *coreReader) readMemory(addr uint64, size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	if size > maxMetadataSize {
+		size = maxMetadataSize
+	}
+	for _, prog := range c.f.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr >= prog.Vaddr+prog.Filesz {
+			continue
+		}
+		if available := prog.Vaddr + prog.Filesz - addr; uint64(size) > available {
+			size = int(available)
+		}
+		data := make([]byte, size)
+		n, _ := prog.ReadAt(data, int64(addr-prog.Vaddr))
+		return data[:n]
+	}
+	return nil
+}
+
+// readString reads a NUL terminated string from the memory of the crashed process
+func (c *coreReader) readString(addr uint64) string {
+	return cString(c.readMemory(addr, 4096))
+}
+
+// prStatus decodes a NT_PRSTATUS note. The registers follow the fixed part
+// of struct elf_prstatus, and the program counter and stack pointer are
+// found for the machines where their positions are known.
+func (c *coreReader) prStatus(desc []byte) coreThread {
+	// The offsets of pr_pid and pr_reg, which depend on the size of a long
+	pidOffset, regOffset := 24, 72
+	if c.wordSize == 8 {
+		pidOffset, regOffset = 32, 112
+	}
+	var thread coreThread
+	thread.TID = c.int32At(desc, pidOffset)
+	if len(desc) >= 14 {
+		if cursig := int(c.f.ByteOrder.Uint16(desc[12:])); cursig != 0 {
+			thread.Signal = signalName(cursig)
+		}
+	}
+	// The register indexes of the program counter and the stack pointer
+	pc, sp := -1, -1
+	switch c.f.Machine {
+	case elf.EM_X86_64:
+		pc, sp = 16, 19
+	case elf.EM_386:
+		pc, sp = 12, 15
+	case elf.EM_AARCH64:
+		pc, sp = 32, 31
+	case elf.EM_ARM:
+		pc, sp = 15, 13
+	case elf.EM_RISCV:
+		pc, sp = 0, 2
+	case elf.EM_PPC64, elf.EM_PPC:
+		pc, sp = 32, 1
+	}
+	if pc >= 0 && regOffset+(pc+1)*c.wordSize <= len(desc) {
+		thread.PC = hexAddr(c.word(desc, regOffset+pc*c.wordSize))
+	}
+	if sp >= 0 && regOffset+(sp+1)*c.wordSize <= len(desc) {
+		thread.SP = hexAddr(c.word(desc, regOffset+sp*c.wordSize))
+	}
+	return thread
+}
+
+// prPsInfo decodes a NT_PRPSINFO note into info. The size of pr_uid and
+// pr_gid differs between architectures, but pr_fname and pr_psargs are
+// always at the end, after pr_pid, pr_ppid, pr_pgrp and pr_sid.
+func (c *coreReader) prPsInfo(desc []byte, info *coreInfo) {
+	const fnameSize, psargsSize = 16, 80
+	fnameOffset := len(desc) - fnameSize - psargsSize
+	pidOffset := fnameOffset - 16
+	// pr_state, pr_sname, pr_zomb and pr_nice, followed by pr_flag, which is a long
+	flagEnd := 8
+	if c.wordSize == 8 {
+		flagEnd = 16
+	}
+	if pidOffset < flagEnd {
+		return
+	}
+	info.State = string(bytes.TrimRight(desc[1:2], "\x00"))
+	info.PID = c.int32At(desc, pidOffset)
+	info.PPID = c.int32At(desc, pidOffset+4)
+	switch idSize := (pidOffset - flagEnd) / 2; idSize {
+	case 2:
+		info.UID = int(c.f.ByteOrder.Uint16(desc[flagEnd:]))
+		info.GID = int(c.f.ByteOrder.Uint16(desc[flagEnd+2:]))
+	case 4:
+		info.UID = c.int32At(desc, flagEnd)
+		info.GID = c.int32At(desc, flagEnd+4)
+	}
+	info.Program = cString(desc[fnameOffset : fnameOffset+fnameSize])
+	info.Args = string(bytes.TrimRight(desc[fnameOffset+fnameSize:], "\x00 "))
+}
+
+// sigInfo decodes a NT_SIGINFO note, which is a siginfo_t
+func (c *coreReader) sigInfo(desc []byte) *coreSignal {
+	if len(desc) < 12 {
+		return nil
+	}
+	sig := &coreSignal{
+		Number: c.int32At(desc, 0),
+		Errno:  c.int32At(desc, 4),
+		Code:   c.int32At(desc, 8),
+	}
+	sig.Name = signalName(sig.Number)
+	sig.CodeName = signalCodeName(sig.Number, sig.Code)
+	// The union that follows si_code is aligned to the size of a pointer
+	union := 12
+	if c.wordSize == 8 {
+		union = 16
+	}
+	switch {
+	case sig.Code <= 0:
+		// Sent by a process, with kill, sigqueue or tkill
+		sig.SenderPID = c.int32At(desc, union)
+	case sig.Code != 0x80:
+		switch sig.Number {
+		case 4, 5, 7, 8, 11:
+			sig.Addr = hexAddr(c.word(desc, union))
+		}
+	}
+	return sig
+}
+
+// auxv decodes a NT_AUXV note, which is a list of pairs of longs
+func (c *coreReader) auxv(desc []byte, info *coreInfo) {
+	for offset := 0; offset+2*c.wordSize <= len(desc); offset += 2 * c.wordSize {
+		key, value := c.word(desc, offset), c.word(desc, offset+c.wordSize)
+		if key == 0 {
+			break
+		}
+		name, ok := auxvNames[key]
+		if !ok {
+			continue
+		}
+		entry := auxvEntry{Name: name, Value: hexAddr(value)}
+		if key == atExecFn || key == atPlatform {
+			if s := c.readString(value); s != "" {
+				entry.Value = s
+			}
+		}
+		if key == atExecFn {
+			info.ExecFn = entry.Value
+		}
+		info.Auxv = append(info.Auxv, entry)
+	}
+}
+
+// mappedFiles decodes a NT_FILE note: the number of entries and the page
+// size, followed by the start, end and page offset of each mapping, and
+// then the paths
+func (c *coreReader) mappedFiles(desc []byte) ([]mappedFile, error) {
+	count, pageSize := c.word(desc, 0), c.word(desc, c.wordSize)
+	offset := 2 * c.wordSize
+	// The entries must fit in what is left after the count and page size
+	if len(desc) < offset || count > uint64((len(desc)-offset)/(3*c.wordSize)) {
+		return nil, errors.New("truncated NT_FILE note")
+	}
+	names := desc[offset+int(count)*3*c.wordSize:]
+	files := make([]mappedFile, 0, count)
+	for i := 0; i < int(count); i++ {
+		start := c.word(desc, offset)
+		end := c.word(desc, offset+c.wordSize)
+		pageOffset := c.word(desc, offset+2*c.wordSize)
+		offset += 3 * c.wordSize
+		name := cString(names)
+		if len(name) < len(names) {
+			names = names[len(name)+1:]
+		} else {
+			names = nil
+		}
+		files = append(files, mappedFile{Path: name, Start: hexAddr(start), End: hexAddr(end), Offset: pageOffset * pageSize, start: start})
+	}
+	return files, nil
+}
+
+// mappedBuildID finds the build-id of an ELF file that is mapped at the
+// given address, from the ELF header and the notes in the memory of the
+// crashed process. These are only available if the first page of the file
+// was included in the core dump, which is the default on Linux.
+func (c *coreReader) mappedBuildID(start uint64) string {
+	header := c.readMemory(start, 64)
+	if len(header) < 52 || string(header[:4]) != elf.ELFMAG {
+		return ""
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if elf.Data(header[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	is64 := elf.Class(header[elf.EI_CLASS]) == elf.ELFCLASS64
+	var phoff uint64
+	var phentsize, phnum int
+	if is64 {
+		if len(header) < 64 {
+			return ""
+		}
+		phoff = order.Uint64(header[32:])
+		phentsize, phnum = int(order.Uint16(header[54:])), int(order.Uint16(header[56:]))
+	} else {
+		phoff = uint64(order.Uint32(header[28:]))
+		phentsize, phnum = int(order.Uint16(header[42:])), int(order.Uint16(header[44:]))
+	}
+	if phentsize == 0 || phentsize*phnum > maxMetadataSize {
+		return ""
+	}
+	phdrs := c.readMemory(start+phoff, phentsize*phnum)
+	if len(phdrs) < phentsize*phnum {
+		return ""
+	}
+	type segment struct {
+		typ                          elf.ProgType
+		offset, vaddr, filesz, align uint64
+	}
+	var segments []segment
+	for i := 0; i < phnum; i++ {
+		ph := phdrs[i*phentsize:]
+		var s segment
+		if is64 {
+			if len(ph) < 56 {
+				return ""
+			}
+			s = segment{elf.ProgType(order.Uint32(ph)), order.Uint64(ph[8:]), order.Uint64(ph[16:]), order.Uint64(ph[32:]), order.Uint64(ph[48:])}
+		} else {
+			if len(ph) < 32 {
+				return ""
+			}
+			s = segment{elf.ProgType(order.Uint32(ph)), uint64(order.Uint32(ph[4:])), uint64(order.Uint32(ph[8:])), uint64(order.Uint32(ph[16:])), uint64(order.Uint32(ph[28:]))}
+		}
+		segments = append(segments, s)
+	}
+	// The load bias is the difference between where the segment with the
+	// ELF header was mapped and where it was linked to be
+	bias, found := uint64(0), false
+	for _, s := range segments {
+		if s.typ == elf.PT_LOAD && s.offset == 0 {
+			bias, found = start-s.vaddr, true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+	for _, s := range segments {
+		if s.typ != elf.PT_NOTE || s.filesz > maxMetadataSize {
+			continue
+		}
+		data := c.readMemory(s.vaddr+bias, int(s.filesz))
+		for _, note := range parseNotes(c.f, data, s.align) {
+			if note.Name == "GNU" && note.Type == ntGNUBuildID {
+				return fmt.Sprintf("%x", note.Desc)
+			}
+		}
+	}
+	return ""
+}
+
+// isCore checks if the given ELF file is a core file
+func isCore(f *elf.File) bool {
+	return f.Type == elf.ET_CORE
+}
+
+// coreInfoFor decodes the notes of a core file
+func coreInfoFor(f *elf.File) (*coreInfo, error) {
+	c := &coreReader{f: f, wordSize: 4}
+	if f.Class == elf.ELFCLASS64 {
+		c.wordSize = 8
+	}
+	info := &coreInfo{Threads: []coreThread{}, Files: []mappedFile{}}
+	for _, note := range readNotes(f) {
+		if note.Name != "CORE" && note.Name != "LINUX" {
+			continue
+		}
+		switch note.Type {
+		case ntPrStatus:
+			info.Threads = append(info.Threads, c.prStatus(note.Desc))
+		case ntPrPsInfo:
+			c.prPsInfo(note.Desc, info)
+		case ntSigInfo:
+			info.Signal = c.sigInfo(note.Desc)
+		case ntAuxv:
+			c.auxv(note.Desc, info)
+		case ntFile:
+			files, err := c.mappedFiles(note.Desc)
+			if err != nil {
+				return nil, err
+			}
+			info.Files = files
+		}
+	}
+	// Look up the build-id of each file once, where the start of the file is mapped
+	buildIDs := make(map[string]string)
+	for _, file := range info.Files {
+		if _, ok := buildIDs[file.Path]; ok || file.Offset != 0 {
+			continue
+		}
+		buildIDs[file.Path] = c.mappedBuildID(file.start)
+	}
+	for i := range info.Files {
+		info.Files[i].BuildID = buildIDs[info.Files[i].Path]
+	}
+	return info, nil
+}
+
+// mappedPaths returns the distinct paths of the mapped files, in the order they are mapped
+func (info *coreInfo) mappedPaths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, file := range info.Files {
+		if !seen[file.Path] {
+			seen[file.Path] = true
+			paths = append(paths, file.Path)
+		}
+	}
+	return paths
+}
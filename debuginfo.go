@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+const ntGNUBuildID = 3 // NT_GNU_BUILD_ID
+
+// debugInfo contains the build-id and debuglink of an ELF file, and the
+// separate debug file that was found for it, if any
+type debugInfo struct {
+	BuildID      string `json:"build_id,omitempty"`      // hex encoded
+	DebugLink    string `json:"debuglink,omitempty"`     // the filename in .gnu_debuglink
+	DebugLinkCRC string `json:"debuglink_crc,omitempty"` // hex encoded CRC32 from .gnu_debuglink
+	DebugFile    string `json:"debug_file,omitempty"`    // the matching debug file, if one was found
+	CRCVerified  *bool  `json:"crc_verified,omitempty"`  // if the CRC of the debug file matches the debuglink
+	Searched     bool   `json:"searched,omitempty"`      // if a debug file was searched for
+}
+
+// buildID returns the hex encoded GNU build-id, or an empty string
+func buildID(f *elf.File) string {
+	for _, note := range readNotes(f) {
+		if note.Name == "GNU" && note.Type == ntGNUBuildID {
+			return hex.EncodeToString(note.Desc)
+		}
+	}
+	return ""
+}
+
+// debugLink returns the filename and CRC32 from the .gnu_debuglink section
+func debugLink(f *elf.File) (string, uint32, bool) {
+	data := compiler.SectionData(f, ".gnu_debuglink")
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 {
+		return "", 0, false
+	}
+	// The CRC follows the filename, at the next 4 byte boundary
+	crcPos := (end + 4) &^ 3
+	if crcPos+4 > len(data) {
+		return "", 0, false
+	}
+	return string(data[:end]), f.ByteOrder.Uint32(data[crcPos:]), true
+}
+
+// debugInfoFor returns the build-id and debuglink of the given ELF file
+func debugInfoFor(f *elf.File) *debugInfo {
+	info := &debugInfo{BuildID: buildID(f)}
+	if name, crc, ok := debugLink(f); ok {
+		info.DebugLink = name
+		info.DebugLinkCRC = fmt.Sprintf("%08x", crc)
+	}
+	return info
+}
+
+// fileCRC calculates the CRC32 of a file, the same way as GDB does for
+// checking files found via .gnu_debuglink
+func fileCRC(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, file); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// isFile checks if the given path is a regular file
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// findDebugFile searches for the separate debug file for filename, the same
+// way as GDB: first by build-id under debugRoot, then by the debuglink
+// filename next to the file, in a .debug directory next to the file and
+// under debugRoot followed by the directory of the file.
+func findDebugFile(info *debugInfo, filename, debugRoot string) {
+	info.Searched = true
+	var candidates []string
+	if len(info.BuildID) > 2 {
+		candidates = append(candidates, filepath.Join(debugRoot, ".build-id", info.BuildID[:2], info.BuildID[2:]+".debug"))
+	}
+	if dir, err := filepath.Abs(filepath.Dir(filename)); err == nil && info.DebugLink != "" {
+		candidates = append(candidates,
+			filepath.Join(dir, info.DebugLink),
+			filepath.Join(dir, ".debug", info.DebugLink),
+			filepath.Join(debugRoot, dir, info.DebugLink))
+	}
+	for _, path := range candidates {
+		// The debug link may refer to the file itself
+		if !isFile(path) || sameFile(path, filename) {
+			continue
+		}
+		info.DebugFile = path
+		// Verify the CRC from the debuglink, if there is one
+		if info.DebugLinkCRC != "" {
+			if crc, err := fileCRC(path); err == nil {
+				verified := fmt.Sprintf("%08x", crc) == info.DebugLinkCRC
+				info.CRCVerified = &verified
+			}
+		}
+		return
+	}
+}
+
+// sameFile checks if two paths refer to the same file
+func sameFile(a, b string) bool {
+	fiA, errA := os.Stat(a)
+	fiB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fiA, fiB)
+}
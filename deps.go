@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// depsInfo lists the dynamic dependencies of an ELF file
+type depsInfo struct {
+	Interpreter string        `json:"interpreter,omitempty"` // from PT_INTERP
+	SONAME      string        `json:"soname,omitempty"`
+	Needed      []string      `json:"needed"`
+	RPATH       []string      `json:"rpath,omitempty"`
+	RUNPATH     []string      `json:"runpath,omitempty"`
+	Resolved    []resolvedLib `json:"resolved,omitempty"` // only present if resolving was requested
+}
+
+// resolvedLib is a needed library and the path it was resolved to
+type resolvedLib struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"` // empty if the library was not found
+}
+
+// interpreter returns the program interpreter from PT_INTERP, or an empty string
+func interpreter(f *elf.File) string {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := segmentData(prog)
+		if err != nil {
+			return ""
+		}
+		return string(bytes.TrimRight(data, "\x00"))
+	}
+	return ""
+}
+
+// splitPaths splits DT_RPATH and DT_RUNPATH values on ":"
+func splitPaths(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		for _, path := range strings.Split(value, ":") {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// dependencies returns the dynamic dependencies of the given ELF file
+func dependencies(f *elf.File) *depsInfo {
+	deps := &depsInfo{
+		Interpreter: interpreter(f),
+		Needed:      dynStrings(f, elf.DT_NEEDED),
+		RPATH:       splitPaths(dynStrings(f, elf.DT_RPATH)),
+		RUNPATH:     splitPaths(dynStrings(f, elf.DT_RUNPATH)),
+	}
+	if sonames := dynStrings(f, elf.DT_SONAME); len(sonames) > 0 {
+		deps.SONAME = sonames[0]
+	}
+	if deps.Needed == nil {
+		deps.Needed = []string{}
+	}
+	return deps
+}
+
+// resolver finds needed libraries the way the dynamic linker would, but
+// without executing anything. All absolute paths are looked up under sysroot.
+type resolver struct {
+	sysroot     string
+	class       elf.Class
+	machine     elf.Machine
+	configDirs  []string // from /etc/ld.so.conf
+	defaultDirs []string
+}
+
+// newResolver creates a resolver for libraries that are compatible with f
+func newResolver(f *elf.File, sysroot string) *resolver {
+	r := &resolver{sysroot: sysroot, class: f.Class, machine: f.Machine}
+	r.configDirs = r.readLdSoConf("/etc/ld.so.conf", make(map[string]bool))
+	if f.Class == elf.ELFCLASS64 {
+		r.defaultDirs = []string{"/lib64", "/usr/lib64"}
+	}
+	r.defaultDirs = append(r.defaultDirs, "/lib", "/usr/lib")
+	return r
+}
+
+// hostPath returns the path on this system for a path under the sysroot
+func (r *resolver) hostPath(path string) string {
+	return filepath.Join(r.sysroot, path)
+}
+
+// readLdSoConf reads the library directories from a ld.so.conf file,
+// following include directives. seen is used for avoiding include loops.
+func (r *resolver) readLdSoConf(confPath string, seen map[string]bool) []string {
+	if seen[confPath] {
+		return nil
+	}
+	seen[confPath] = true
+	file, err := os.Open(r.hostPath(confPath))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var dirs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pos := strings.IndexByte(line, '#'); pos != -1 {
+			line = line[:pos]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "include":
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(confPath), pattern)
+				}
+				matches, _ := filepath.Glob(r.hostPath(pattern))
+				for _, match := range matches {
+					rel, err := filepath.Rel(r.hostPath("/"), match)
+					if err != nil {
+						continue
+					}
+					dirs = append(dirs, r.readLdSoConf("/"+rel, seen)...)
+				}
+			}
+		case fields[0] == "hwcap":
+		default:
+			dirs = append(dirs, fields...)
+		}
+	}
+	return dirs
+}
+
+// searchDir is a directory to search for libraries in
+type searchDir struct {
+	path string
+	host bool // the path is on this system, and not under the sysroot
+}
+
+// searchDirs expands $ORIGIN, $LIB and $PLATFORM in the given search paths.
+// origin is the directory, on this system, of the object that the search
+// paths came from. Paths with $ORIGIN are then relative to this system,
+// while all other paths are under the sysroot.
+func (r *resolver) searchDirs(paths []string, origin string) []searchDir {
+	lib := "lib"
+	if r.class == elf.ELFCLASS64 {
+		lib = "lib64"
+	}
+	platform := strings.ToLower(strings.TrimPrefix(r.machine.String(), "EM_"))
+	if r.machine == elf.EM_X86_64 {
+		platform = "x86_64"
+	}
+	dirs := make([]searchDir, 0, len(paths))
+	for _, path := range paths {
+		host := strings.Contains(path, "$ORIGIN") || strings.Contains(path, "${ORIGIN}")
+		for _, v := range []struct{ name, value string }{{"ORIGIN", origin}, {"LIB", lib}, {"PLATFORM", platform}} {
+			path = strings.Replace(path, "${"+v.name+"}", v.value, -1)
+			path = strings.Replace(path, "$"+v.name, v.value, -1)
+		}
+		dirs = append(dirs, searchDir{path, host})
+	}
+	return dirs
+}
+
+// compatible checks if the file at the given host path is an ELF file
+// with the same class and machine
+func (r *resolver) compatible(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return f.Class == r.class && f.Machine == r.machine
+}
+
+// find searches for a library in the given directories, and returns the
+// path on this system, or an empty string
+func (r *resolver) find(name string, dirs []searchDir) string {
+	for _, dir := range dirs {
+		path := filepath.Join(dir.path, name)
+		if !dir.host {
+			path = r.hostPath(path)
+		}
+		if r.compatible(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// resolve finds the path of every needed library, including the libraries
+// that are needed by those, in breadth-first order like ldd.
+// LD_LIBRARY_PATH is ignored, since the result should not depend on the environment.
+func (r *resolver) resolve(filename string, deps *depsInfo) []resolvedLib {
+	type pending struct {
+		name    string
+		rpath   []searchDir // the DT_RPATH of the loading objects, used if there is no DT_RUNPATH
+		runpath []searchDir
+	}
+	origin, _ := filepath.Abs(filepath.Dir(filename))
+	var rpath []searchDir
+	if len(deps.RUNPATH) == 0 {
+		rpath = r.searchDirs(deps.RPATH, origin)
+	}
+	runpath := r.searchDirs(deps.RUNPATH, origin)
+	var queue []pending
+	for _, name := range deps.Needed {
+		queue = append(queue, pending{name, rpath, runpath})
+	}
+	systemDirs := r.searchDirs(append(append([]string{}, r.configDirs...), r.defaultDirs...), origin)
+	seen := make(map[string]bool)
+	var resolved []resolvedLib
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p.name] {
+			continue
+		}
+		seen[p.name] = true
+
+		var path string
+		if strings.Contains(p.name, "/") {
+			// Like the dynamic linker, names with a slash are used as
+			// paths, which are relative to the current directory
+			path = r.hostPath(p.name)
+			if !filepath.IsAbs(p.name) {
+				path, _ = filepath.Abs(p.name)
+			}
+			if !r.compatible(path) {
+				path = ""
+			}
+		} else {
+			var dirs []searchDir
+			if len(p.runpath) == 0 {
+				dirs = append(dirs, p.rpath...)
+			}
+			dirs = append(dirs, p.runpath...)
+			dirs = append(dirs, systemDirs...)
+			path = r.find(p.name, dirs)
+		}
+		resolved = append(resolved, resolvedLib{Name: p.name, Path: path})
+		if path == "" {
+			continue
+		}
+
+		// Queue the libraries that this library needs
+		lib, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		libDeps := dependencies(lib)
+		lib.Close()
+		libOrigin := filepath.Dir(path)
+		libRpath := p.rpath
+		if len(libDeps.RUNPATH) == 0 {
+			libRpath = append(r.searchDirs(libDeps.RPATH, libOrigin), p.rpath...)
+		}
+		libRunpath := r.searchDirs(libDeps.RUNPATH, libOrigin)
+		for _, name := range libDeps.Needed {
+			queue = append(queue, pending{name, libRpath, libRunpath})
+		}
+	}
+	return resolved
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// fileDevice returns the device ID for the given file info, if available
+func fileDevice(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// fileIdentity returns the device ID and inode number for the given file
+// info, if available
+func fileIdentity(fi os.FileInfo) (uint64, uint64, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// change is a difference between two ELF files
+type change struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"` // empty if something was added
+	New   string `json:"new,omitempty"` // empty if something was removed
+}
+
+// diffResult lists the differences between two ELF files
+type diffResult struct {
+	Old     string   `json:"old"`
+	New     string   `json:"new"`
+	Changes []change `json:"changes"`
+}
+
+// diffOptions selects what is examined when comparing files
+var diffOptions = examineOptions{security: true, deps: true, sections: true}
+
+// examineOne examines a single ELF file, for comparing it with another one
+func examineOne(filename string) (*result, error) {
+	var results []*result
+	examine(filename, diffOptions, func(res *result) {
+		results = append(results, res)
+	})
+	if len(results) != 1 {
+		return nil, fmt.Errorf("%s: only single ELF files can be compared", filename)
+	}
+	if results[0].err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, results[0].err)
+	}
+	return results[0], nil
+}
+
+// compareValues adds a change if the old and new values differ
+func compareValues(changes []change, field string, oldValue, newValue interface{}) []change {
+	o, n := fmt.Sprintf("%v", oldValue), fmt.Sprintf("%v", newValue)
+	if o != n {
+		changes = append(changes, change{Field: field, Old: o, New: n})
+	}
+	return changes
+}
+
+// compareLists adds a change for every item that was removed or added
+func compareLists(changes []change, field string, oldItems, newItems []string) []change {
+	inOld := make(map[string]bool, len(oldItems))
+	for _, item := range oldItems {
+		inOld[item] = true
+	}
+	inNew := make(map[string]bool, len(newItems))
+	for _, item := range newItems {
+		inNew[item] = true
+	}
+	for _, item := range oldItems {
+		if !inNew[item] {
+			changes = append(changes, change{Field: field, Old: item})
+		}
+	}
+	for _, item := range newItems {
+		if !inOld[item] {
+			changes = append(changes, change{Field: field, New: item})
+		}
+	}
+	return changes
+}
+
+// compareResults returns the differences in toolchain, hardening,
+// dependencies, build-id and section sizes between two examined files
+func compareResults(a, b *result) []change {
+	changes := []change{}
+	changes = compareValues(changes, "compiler", a.Compiler, b.Compiler)
+	changes = compareValues(changes, "static", a.Static, b.Static)
+	changes = compareValues(changes, "stripped", a.Stripped, b.Stripped)
+	changes = compareValues(changes, "machine", a.Machine, b.Machine)
+	changes = compareValues(changes, "class", a.Class, b.Class)
+	changes = compareValues(changes, "byteorder", a.ByteOrder, b.ByteOrder)
+	if a.Deps != nil && b.Deps != nil {
+		changes = compareValues(changes, "interpreter", orNone(a.Deps.Interpreter), orNone(b.Deps.Interpreter))
+		changes = compareValues(changes, "soname", orNone(a.Deps.SONAME), orNone(b.Deps.SONAME))
+		changes = compareLists(changes, "needed", a.Deps.Needed, b.Deps.Needed)
+	}
+	if a.Security != nil && b.Security != nil {
+		sa, sb := a.Security, b.Security
+		changes = compareValues(changes, "relro", sa.RELRO, sb.RELRO)
+		changes = compareValues(changes, "nx", sa.NX, sb.NX)
+		changes = compareValues(changes, "pie", sa.PIE, sb.PIE)
+		changes = compareValues(changes, "canary", sa.Canary, sb.Canary)
+		changes = compareValues(changes, "fortify", sa.Fortify, sb.Fortify)
+		changes = compareValues(changes, "ibt", sa.IBT, sb.IBT)
+		changes = compareValues(changes, "shstk", sa.SHSTK, sb.SHSTK)
+		changes = compareLists(changes, "rpath", sa.RPATH, sb.RPATH)
+		changes = compareLists(changes, "runpath", sa.RUNPATH, sb.RUNPATH)
+	}
+	if a.Debug != nil && b.Debug != nil {
+		changes = compareValues(changes, "buildid", orNone(a.Debug.BuildID), orNone(b.Debug.BuildID))
+	}
+
+	// Compare the section sizes, in the order of the sections in the new file
+	oldSizes := make(map[string]uint64)
+	for _, sec := range a.Sections {
+		oldSizes[sec.Name] = sec.Size
+	}
+	newSizes := make(map[string]bool)
+	for _, sec := range b.Sections {
+		newSizes[sec.Name] = true
+	}
+	for _, sec := range a.Sections {
+		if sec.Name != "" && !newSizes[sec.Name] {
+			changes = append(changes, change{Field: "section " + sec.Name, Old: strconv.FormatUint(sec.Size, 10)})
+		}
+	}
+	for _, sec := range b.Sections {
+		if sec.Name == "" {
+			continue
+		}
+		oldSize, ok := oldSizes[sec.Name]
+		switch {
+		case !ok:
+			changes = append(changes, change{Field: "section " + sec.Name, New: strconv.FormatUint(sec.Size, 10)})
+		case oldSize != sec.Size:
+			changes = append(changes, change{Field: "section " + sec.Name, Old: strconv.FormatUint(oldSize, 10), New: strconv.FormatUint(sec.Size, 10)})
+		}
+	}
+	return changes
+}
+
+// diffFiles compares two ELF files
+func diffFiles(oldName, newName string) (*diffResult, error) {
+	a, err := examineOne(oldName)
+	if err != nil {
+		return nil, err
+	}
+	b, err := examineOne(newName)
+	if err != nil {
+		return nil, err
+	}
+	return &diffResult{Old: oldName, New: newName, Changes: compareResults(a, b)}, nil
+}
+
+// sizeDelta returns the difference between two sizes, like "+87", or an
+// empty string if they are not numbers
+func sizeDelta(oldValue, newValue string) string {
+	o, errOld := strconv.ParseInt(oldValue, 10, 64)
+	n, errNew := strconv.ParseInt(newValue, 10, 64)
+	if errOld != nil || errNew != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (%+d)", n-o)
+}
+
+// printDiff outputs the differences in the given format. In the text
+// format, only what changed is output, one line per change.
+func printDiff(w io.Writer, d *diffResult, format string, noColor bool) error {
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case formatNDJSON:
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case formatText:
+	default:
+		return errors.New("unknown output format: " + format)
+	}
+	colored := func(s, color string) string {
+		if noColor {
+			return s
+		}
+		return "\033[" + color + "m" + s + "\033[0m"
+	}
+	for _, c := range d.Changes {
+		var line string
+		switch {
+		case c.Old == "":
+			line = colored("+ "+c.New, "1;32")
+		case c.New == "":
+			line = colored("- "+c.Old, "1;31")
+		default:
+			line = colored(c.Old, "1;31") + " -> " + colored(c.New, "1;32")
+			if strings.HasPrefix(c.Field, "section ") {
+				line += sizeDelta(c.Old, c.New)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", c.Field, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"io/ioutil"
+)
+
+// maxMetadataSize is the largest segment or section that is read for the
+// interpreter, the dynamic section and the notes. Those are small in real
+// files, but the sizes in the headers can not be trusted.
+const maxMetadataSize = 16 << 20
+
+var errTooLarge = errors.New("too large")
+
+// segmentData reads the contents of a segment. The data is read as it
+// comes, so a size that is larger than the file never gets allocated, and
+// segments that are larger than maxMetadataSize or extend past the end of
+// the file are rejected.
+func segmentData(prog *elf.Prog) ([]byte, error) {
+	if prog.Filesz > maxMetadataSize {
+		return nil, errTooLarge
+	}
+	data, err := ioutil.ReadAll(prog.Open())
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != prog.Filesz {
+		return nil, errors.New("segment extends past the end of the file")
+	}
+	return data, nil
+}
+
+// metadataSection reads the contents of a section, which is rejected if
+// it is larger than maxMetadataSize
+func metadataSection(sec *elf.Section) ([]byte, error) {
+	if sec.Size > maxMetadataSize || sec.FileSize > maxMetadataSize {
+		return nil, errTooLarge
+	}
+	return sec.Data()
+}
+
+// dynEntry is a single entry in the dynamic section
+type dynEntry struct {
+	Tag elf.DynTag
+	Val uint64
+}
+
+// dynamicEntries returns the entries of the dynamic section, up to DT_NULL.
+// Returns nil if there is no dynamic section.
+func dynamicEntries(f *elf.File) []dynEntry {
+	var data []byte
+	if sec := f.SectionByType(elf.SHT_DYNAMIC); sec != nil {
+		data, _ = metadataSection(sec)
+	} else {
+		for _, prog := range f.Progs {
+			if prog.Type == elf.PT_DYNAMIC {
+				var err error
+				if data, err = segmentData(prog); err != nil {
+					return nil
+				}
+				break
+			}
+		}
+	}
+	var entries []dynEntry
+	for len(data) > 0 {
+		var e dynEntry
+		switch f.Class {
+		case elf.ELFCLASS32:
+			if len(data) < 8 {
+				return entries
+			}
+			e = dynEntry{elf.DynTag(f.ByteOrder.Uint32(data)), uint64(f.ByteOrder.Uint32(data[4:]))}
+			data = data[8:]
+		case elf.ELFCLASS64:
+			if len(data) < 16 {
+				return entries
+			}
+			e = dynEntry{elf.DynTag(f.ByteOrder.Uint64(data)), f.ByteOrder.Uint64(data[8:])}
+			data = data[16:]
+		default:
+			return nil
+		}
+		if e.Tag == elf.DT_NULL {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// dynValue returns the value of the first dynamic entry with the given tag
+func dynValue(entries []dynEntry, tag elf.DynTag) (uint64, bool) {
+	for _, e := range entries {
+		if e.Tag == tag {
+			return e.Val, true
+		}
+	}
+	return 0, false
+}
+
+// dynStrings returns the strings for the given dynamic tag, like DT_NEEDED.
+// Errors are ignored, since a missing string table just means no strings.
+func dynStrings(f *elf.File, tag elf.DynTag) []string {
+	strs, _ := f.DynString(tag)
+	return strs
+}
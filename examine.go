@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/xyproto/ainur"
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// errNotELF is returned by examine when the given file is not an ELF file
+var errNotELF = errors.New("not an ELF")
+
+// examineOptions selects which optional information examine should collect
+type examineOptions struct {
+	allCompilers bool // list every detected toolchain
+	comments     bool // list every entry in the .comment section
+	security     bool // examine the hardening features
+	deps         bool // list the dynamic dependencies
+	resolve      bool // resolve the dynamic dependencies to paths
+	sysroot      string
+	findDebug    bool // search for separate debug files
+	debugRoot    string
+	sections     bool // list the section headers
+	segments     bool // list the program headers
+	size         bool // attribute the file size to sections, symbols and groups of symbols
+	topSymbols   int  // the number of symbols to list in the size report
+	hashes       bool // compute the checksums of the file
+}
+
+// examine opens the given file, or reads from stdin if the filename is "-",
+// and calls emit with the result of examineReaderAt. Compressed streams and
+// archives are searched for ELF files, and emit is called once per ELF file.
+func examine(filename string, opts examineOptions, emit func(*result)) {
+	fail := func(err error) {
+		emit(&result{Filename: filename, Error: err.Error(), err: err})
+	}
+	scanner := &archiveScanner{opts: opts, emit: emit, path: filename}
+	if filename == "-" {
+		// ELF files need random access, so stdin is read into memory
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if !isContainer(sniff(data)) {
+			emit(examineReaderAt(bytes.NewReader(data), filename, opts))
+			return
+		}
+		if err := scanner.scan(filename, bytes.NewReader(data), 0); err != nil {
+			fail(err)
+		}
+		return
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer file.Close()
+	head := make([]byte, sniffLength)
+	n, _ := file.ReadAt(head, 0)
+	if !isContainer(sniff(head[:n])) {
+		emit(examineReaderAt(file, filename, opts))
+		return
+	}
+	if err := scanner.scan(filename, file, 0); err != nil {
+		fail(err)
+	}
+}
+
+// examineReaderAt tries to detect compiler name and compiler version from
+// the ELF data in r, together with the stripped status, byte order and
+// target machine, and any optional information selected by opts. For core
+// files, the crashed process is described instead. name is
+// used as the filename in the result, and for finding files relative to
+// the examined file. If the data could not be examined, the error is set
+// in the result.
+func examineReaderAt(r io.ReaderAt, name string, opts examineOptions) *result {
+	res := &result{Filename: name}
+	fail := func(err error) *result {
+		res.err = err
+		res.Error = err.Error()
+		return res
+	}
+	// Check the magic bytes first, to be able to skip other files quietly
+	var magic [len(elf.ELFMAG)]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		if strings.Contains(err.Error(), "is a directory") {
+			err = errors.New("is a directory")
+		}
+		return fail(err)
+	}
+	if string(magic[:]) != elf.ELFMAG {
+		return fail(errNotELF)
+	}
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fail(err)
+	}
+
+	// Use the short version of LittleEndian and BigEndian
+	res.ByteOrder = strings.Replace(strings.Replace(f.ByteOrder.String(), "LittleEndian", "LE", 1), "BigEndian", "BE", 1)
+	res.Machine = ainur.Describe(f.Machine)
+	res.Class = strings.Replace(f.Class.String(), "ELFCLASS", "ELF", 1)
+	if opts.hashes {
+		if res.Hashes, err = fileHashes(r); err != nil {
+			return fail(err)
+		}
+	}
+	if opts.sections {
+		res.Sections = sections(f)
+	}
+	if opts.segments {
+		res.Segments = segments(f)
+	}
+	if opts.size {
+		fileSize, _ := readerSize(r)
+		res.Size = sizeReportFor(f, fileSize, opts.topSymbols)
+	}
+	if isCore(f) {
+		// Compilers, symbols and dependencies do not apply to core files
+		res.Compiler = res.CompilerInfo.String()
+		if res.Core, err = coreInfoFor(f); err != nil {
+			return fail(err)
+		}
+		return res
+	}
+
+	ef := compiler.NewFile(f, r)
+	defer ef.Close()
+	info, err := compiler.Detect(ef)
+	if err != nil {
+		return fail(err)
+	}
+	if info != nil {
+		res.CompilerName, res.CompilerVersion = info.Name, info.Version
+		res.CompilerInfo = info
+	}
+	res.Compiler = res.CompilerInfo.String()
+	if info, err := compiler.ReadGoBuildInfo(f); err == nil {
+		res.GoBuildInfo = info
+	}
+	if packages, err := rustPackages(f); err == nil {
+		res.RustPackages = packages
+	}
+	if opts.allCompilers {
+		if res.Compilers, err = compiler.DetectAll(ef); err != nil {
+			return fail(err)
+		}
+		if res.Compilers == nil {
+			res.Compilers = []*compiler.Info{}
+		}
+	}
+	res.Debug = debugInfoFor(f)
+	if opts.findDebug {
+		findDebugFile(res.Debug, name, opts.debugRoot)
+	}
+	if opts.security {
+		res.Security = security(f)
+	}
+	if opts.deps || opts.resolve {
+		res.Deps = dependencies(f)
+		if opts.resolve {
+			res.Deps.Resolved = newResolver(f, opts.sysroot).resolve(name, res.Deps)
+		}
+	}
+	if opts.comments {
+		res.Comments = compiler.Producers(f)
+		if res.Comments == nil {
+			res.Comments = []compiler.Producer{}
+		}
+	}
+	res.Stripped = ainur.Stripped(f)
+	res.Static = ainur.Static(f)
+	return res
+}
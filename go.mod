@@ -1,8 +1,10 @@
 module github.com/xyproto/elfinfo
 
-go 1.11
+go 1.21
 
 require (
 	github.com/docopt/docopt-go v0.0.0-20180111231733-ee0de3bc6815
+	github.com/klauspost/compress v1.17.11
+	github.com/ulikunitz/xz v0.5.15
 	github.com/xyproto/ainur v1.3.3
 )
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// maxImageMetadataSize is the largest manifest, index or config file that is read
+	maxImageMetadataSize = 4 * 1024 * 1024
+)
+
+// imageLayer is a layer in a container image tarball
+type imageLayer struct {
+	path   string // the path of the layer within the image tarball
+	digest string // like "sha256:...", if known
+}
+
+// dockerManifest is an entry in the manifest.json file written by "docker save"
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// dockerConfig is the part of the image config that lists the layer digests
+type dockerConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ociDescriptor refers to a blob in an OCI image layout
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// ociIndex is an OCI image index, like index.json
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// imageTarball provides access to the files in a container image tarball,
+// which is read again from the start for every file that is opened
+type imageTarball struct {
+	file *os.File
+}
+
+// open finds the file with the given path in the tarball and returns a
+// reader for it. The reader is valid until open is called again.
+func (t *imageTarball) open(name string) (io.Reader, error) {
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	tr := tar.NewReader(t.file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: not found in the image", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(strings.TrimPrefix(hdr.Name, "./")) == name {
+			return tr, nil
+		}
+	}
+}
+
+// readJSON reads and decodes a JSON file from the tarball
+func (t *imageTarball) readJSON(name string, v interface{}) error {
+	r, err := t.open(name)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxImageMetadataSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// blobPath returns the path of a blob in an OCI image layout
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// layers returns the layers of every image in the tarball, with the lowest
+// layer first. Both "docker save" tarballs and OCI image layouts are supported.
+func (t *imageTarball) layers() ([][]imageLayer, error) {
+	var manifests []dockerManifest
+	if err := t.readJSON("manifest.json", &manifests); err == nil {
+		var images [][]imageLayer
+		for _, manifest := range manifests {
+			var config dockerConfig
+			t.readJSON(manifest.Config, &config)
+			var layers []imageLayer
+			for i, layerPath := range manifest.Layers {
+				layer := imageLayer{path: layerPath}
+				if i < len(config.RootFS.DiffIDs) {
+					layer.digest = config.RootFS.DiffIDs[i]
+				}
+				layers = append(layers, layer)
+			}
+			images = append(images, layers)
+		}
+		return images, nil
+	}
+	var index ociIndex
+	if err := t.readJSON("index.json", &index); err != nil {
+		return nil, errors.New("not a container image: no manifest.json or index.json")
+	}
+	var images [][]imageLayer
+	// Nested indexes, like for multi-platform images, are followed
+	for depth := 0; len(index.Manifests) > 0 && depth < 4; depth++ {
+		var nested ociIndex
+		for _, desc := range index.Manifests {
+			if strings.HasSuffix(desc.MediaType, "index.v1+json") || strings.HasSuffix(desc.MediaType, "manifest.list.v2+json") {
+				var sub ociIndex
+				if err := t.readJSON(blobPath(desc.Digest), &sub); err == nil {
+					nested.Manifests = append(nested.Manifests, sub.Manifests...)
+				}
+				continue
+			}
+			var manifest ociManifest
+			if err := t.readJSON(blobPath(desc.Digest), &manifest); err != nil {
+				return nil, err
+			}
+			var layers []imageLayer
+			for _, layer := range manifest.Layers {
+				layers = append(layers, imageLayer{path: blobPath(layer.Digest), digest: layer.Digest})
+			}
+			images = append(images, layers)
+		}
+		index = nested
+	}
+	return images, nil
+}
+
+// layerFilter keeps track of which paths have been provided or deleted by
+// the upper layers, when the layers are processed from the top down
+type layerFilter struct {
+	decided map[string]bool // paths that are provided by an upper layer
+	hidden  map[string]bool // paths that are deleted, including everything below them
+	opaque  map[string]bool // directories where the contents of the lower layers are hidden
+}
+
+// newLayerFilter returns an empty layerFilter
+func newLayerFilter() *layerFilter {
+	return &layerFilter{
+		decided: make(map[string]bool),
+		hidden:  make(map[string]bool),
+		opaque:  make(map[string]bool),
+	}
+}
+
+// visible checks if a path in the current layer is part of the final filesystem
+func (lf *layerFilter) visible(name string) bool {
+	if lf.decided[name] || lf.hidden[name] {
+		return false
+	}
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if lf.hidden[dir] || lf.opaque[dir] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge adds the changes from a layer, which apply to the layers below it
+func (lf *layerFilter) merge(other *layerFilter) {
+	for name := range other.decided {
+		lf.decided[name] = true
+	}
+	for name := range other.hidden {
+		lf.hidden[name] = true
+	}
+	for name := range other.opaque {
+		lf.opaque[name] = true
+	}
+}
+
+// examineImage examines every ELF file in the final filesystem of a
+// container image tarball, after applying the layers and their whiteouts.
+// The results are named like "image.tar!/usr/bin/foo" and have the digest
+// of the layer the file came from.
+func examineImage(filename string, opts examineOptions, emit func(*result)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	t := &imageTarball{file: file}
+	images, err := t.layers()
+	if err != nil {
+		return err
+	}
+	for _, layers := range images {
+		filter := newLayerFilter()
+		// Process the layers from the top down, so that each file is
+		// examined only if it is in the final filesystem
+		for i := len(layers) - 1; i >= 0; i-- {
+			changes, err := examineLayer(t, filename, layers[i], filter, opts, emit)
+			if err != nil {
+				return err
+			}
+			filter.merge(changes)
+		}
+	}
+	return nil
+}
+
+// examineLayer examines the visible ELF files in a single layer, and
+// returns the paths that the layer provides or deletes
+func examineLayer(t *imageTarball, filename string, layer imageLayer, filter *layerFilter, opts examineOptions, emit func(*result)) (*layerFilter, error) {
+	r, err := t.open(layer.path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(sniffLength)
+	var layerData io.Reader = br
+	if kind := sniff(head); kind != kindTar && kind != kindUnknown {
+		rc, err := decompress(br, kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", layer.path, err)
+		}
+		defer rc.Close()
+		layerData = rc
+	}
+	changes := newLayerFilter()
+	tr := tar.NewReader(layerData)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return changes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", layer.path, err)
+		}
+		name := path.Join("/", hdr.Name)
+		base := path.Base(name)
+		switch {
+		case base == whiteoutOpaque:
+			changes.opaque[path.Dir(name)] = true
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			changes.hidden[path.Join(path.Dir(name), base[len(whiteoutPrefix):])] = true
+			continue
+		}
+		if !filter.visible(name) {
+			continue
+		}
+		changes.decided[name] = true
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		// Anything that is not a directory replaces the lower contents
+		changes.hidden[name] = true
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		// Only read the files that are ELF files into memory
+		fileReader := bufio.NewReader(tr)
+		if magic, _ := fileReader.Peek(len(elf.ELFMAG)); string(magic) != elf.ELFMAG {
+			continue
+		}
+		ra, remove, err := readMember(fileReader)
+		if err == errTooLarge {
+			emit(&result{Filename: memberName(filename, name), Error: err.Error(), err: err, Layer: layer.digest, inArchive: true})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := examineReaderAt(ra, memberName(filename, name), opts)
+		remove()
+		res.Layer = layer.digest
+		res.inArchive = true
+		emit(res)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"debug/elf"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// sectionEntry describes a section header
+type sectionEntry struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`  // like "PROGBITS"
+	Flags   string   `json:"flags"` // like "AX", with the same letters as readelf
+	Addr    string   `json:"addr"`
+	Offset  uint64   `json:"offset"`
+	Size    uint64   `json:"size"`
+	Align   uint64   `json:"align"`
+	Entropy *float64 `json:"entropy,omitempty"` // in bits per byte, for sections with data in the file
+}
+
+// segmentEntry describes a program header
+type segmentEntry struct {
+	Type    string   `json:"type"`  // like "LOAD"
+	Flags   string   `json:"flags"` // like "R E", as readelf shows them
+	Offset  uint64   `json:"offset"`
+	Vaddr   string   `json:"vaddr"`
+	Paddr   string   `json:"paddr"`
+	Filesz  uint64   `json:"filesz"`
+	Memsz   uint64   `json:"memsz"`
+	Align   uint64   `json:"align"`
+	Entropy *float64 `json:"entropy,omitempty"` // in bits per byte, for segments with data in the file
+}
+
+// sectionFlagLetters are the letters that readelf uses for section flags
+var sectionFlagLetters = []struct {
+	flag   elf.SectionFlag
+	letter string
+}{
+	{elf.SHF_WRITE, "W"},
+	{elf.SHF_ALLOC, "A"},
+	{elf.SHF_EXECINSTR, "X"},
+	{elf.SHF_MERGE, "M"},
+	{elf.SHF_STRINGS, "S"},
+	{elf.SHF_INFO_LINK, "I"},
+	{elf.SHF_LINK_ORDER, "L"},
+	{elf.SHF_OS_NONCONFORMING, "O"},
+	{elf.SHF_GROUP, "G"},
+	{elf.SHF_TLS, "T"},
+	{elf.SHF_COMPRESSED, "C"},
+}
+
+// sectionFlags returns the section flags as letters, like "WA"
+func sectionFlags(flags elf.SectionFlag) string {
+	var letters string
+	for _, fl := range sectionFlagLetters {
+		if flags&fl.flag != 0 {
+			letters += fl.letter
+		}
+	}
+	return letters
+}
+
+// segmentFlags returns the segment flags as readelf shows them, like "R E"
+func segmentFlags(flags elf.ProgFlag) string {
+	letters := []byte("   ")
+	if flags&elf.PF_R != 0 {
+		letters[0] = 'R'
+	}
+	if flags&elf.PF_W != 0 {
+		letters[1] = 'W'
+	}
+	if flags&elf.PF_X != 0 {
+		letters[2] = 'E'
+	}
+	return string(letters)
+}
+
+// entropy calculates the Shannon entropy of the data in r, in bits per
+// byte, rounded to three decimals. Values close to 8 are typical for
+// compressed or encrypted data.
+func entropy(r io.Reader) (float64, error) {
+	var counts [256]uint64
+	var total uint64
+	buf := make([]byte, compiler.BufferSize)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		total += uint64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	var bits float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(total)
+		bits -= p * math.Log2(p)
+	}
+	return math.Round(bits*1000) / 1000, nil
+}
+
+// entropyOf returns the entropy of size bytes from r, or nil if there is
+// no data or it could not be read
+func entropyOf(r io.ReaderAt, size uint64) *float64 {
+	if size == 0 {
+		return nil
+	}
+	e, err := entropy(io.NewSectionReader(r, 0, int64(size)))
+	if err != nil {
+		return nil
+	}
+	return &e
+}
+
+// sections lists every section header. The entropy is calculated for the
+// data as it is stored in the file, even if the section is compressed.
+func sections(f *elf.File) []sectionEntry {
+	entries := []sectionEntry{}
+	for _, sec := range f.Sections {
+		entry := sectionEntry{
+			Name:   sec.Name,
+			Type:   strings.TrimPrefix(sec.Type.String(), "SHT_"),
+			Flags:  sectionFlags(sec.Flags),
+			Addr:   hexAddr(sec.Addr),
+			Offset: sec.Offset,
+			Size:   sec.Size,
+			Align:  sec.Addralign,
+		}
+		if sec.Type != elf.SHT_NOBITS && sec.Type != elf.SHT_NULL {
+			entry.Entropy = entropyOf(sec.ReaderAt, sec.FileSize)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// segments lists every program header
+func segments(f *elf.File) []segmentEntry {
+	entries := []segmentEntry{}
+	for _, prog := range f.Progs {
+		entries = append(entries, segmentEntry{
+			Type:    strings.TrimPrefix(prog.Type.String(), "PT_"),
+			Flags:   segmentFlags(prog.Flags),
+			Offset:  prog.Off,
+			Vaddr:   hexAddr(prog.Vaddr),
+			Paddr:   hexAddr(prog.Paddr),
+			Filesz:  prog.Filesz,
+			Memsz:   prog.Memsz,
+			Align:   prog.Align,
+			Entropy: entropyOf(prog.ReaderAt, prog.Filesz),
+		})
+	}
+	return entries
+}
@@ -2,14 +2,14 @@
 package main
 
 import (
-	"debug/elf"
 	"fmt"
 	"os"
 	"path"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/docopt/docopt-go"
-	"github.com/xyproto/ainur"
 )
 
 const (
@@ -19,15 +19,40 @@ const (
 	usage = versionString + "\n" + description + `
 
 Usage:
-  elfinfo [-l | --long] [-c | --color] <ELF>
+  elfinfo diff [-c | --color] [--format=<format>] [--signatures=<file>] <old> <new>
+  elfinfo [-l | --long] [-a | --all] [--comments] [-s | --security] [-d | --deps] [--resolve] [--sysroot=<dir>] [--find-debug] [--debug-root=<dir>] [--image] [--sections] [--segments] [--size] [--top=<n>] [--policy=<file>] [--signatures=<file>] [--sbom=<format>] [-j <n> | --jobs=<n>] [--unordered] [--progress] [--cache] [--cache-dir=<dir>] [-c | --color] [-L | --follow-symlinks] [-x | --one-file-system] [--format=<format>] <ELF>...
   elfinfo -h | --help
   elfinfo --version
 
 Options:
-  -c --color       Color the text output (unless NO_COLOR is set).
-  -h --help        Show this screen.
-  -l --long        Also output stripped status, byte order and target machine.
-  --version        Version info.
+  -a --all                Report every detected toolchain, not only the first.
+  --comments              List every producer string in the .comment section.
+  -s --security           Report hardening features, like checksec.
+  -d --deps               List the dynamic dependencies, interpreter and search paths.
+  --resolve               Also find the needed libraries, like ldd, without executing anything.
+  --sysroot=<dir>         Look for libraries and /etc/ld.so.conf under this directory [default: /].
+  --find-debug            Search for the separate debug file of each file.
+  --debug-root=<dir>      The directory with debug files [default: /usr/lib/debug].
+  --image                 Examine docker save or OCI image tarballs, layer by layer.
+  --sections              List the section headers, with the entropy of each section.
+  --segments              List the program headers, with the entropy of each segment.
+  --size                  Attribute the file size to sections, symbols and packages, crates or namespaces.
+  --top=<n>               The number of symbols to list with --size [default: 20].
+  --policy=<file>         Check each file against the rules in a YAML or JSON policy file.
+  --signatures=<file>     Also detect compilers with the signatures in a YAML or JSON file.
+  --sbom=<format>         Write an SBOM for the files instead: cyclonedx or spdx.
+  -j <n> --jobs=<n>       The number of files to examine at the same time, or 0 for one per CPU [default: 0].
+  --unordered             Output the results as soon as they are ready, not in the order the files were found.
+  --progress              Show the number of examined files and the throughput on stderr.
+  --cache                 Store the results on disk, and use them for files that are unchanged.
+  --cache-dir=<dir>       Use this cache directory, instead of $XDG_CACHE_HOME/elfinfo.
+  -c --color              Color the text output (unless NO_COLOR is set).
+  --format=<format>       Output format: text, json or ndjson [default: text].
+  -h --help               Show this screen.
+  -l --long               Also output stripped status, byte order and target machine.
+  -L --follow-symlinks    Follow symbolic links when scanning directories.
+  -x --one-file-system    Do not descend into directories on other filesystems.
+  --version               Version info.
 `
 )
 
@@ -48,47 +73,32 @@ func which(filename string) (string, error) {
 	return "", fmt.Errorf("%s: no such file or directory", filename)
 }
 
-// examine tries to detect compiler name and compiler version from a given
-// ELF filename.
-func examine(filename string, onlyCompilerInfo, noColor bool) {
-	f, err := elf.Open(filename)
-	if err != nil {
-		if strings.Contains(err.Error(), "bad magic number '[") {
-			if noColor {
-				fmt.Printf("%s: %s\n", filename, "not an ELF")
-			} else {
-				fmt.Printf("\033[1;33m%s: %s\033[0m\n", filename, "not an ELF")
-			}
-		} else if strings.Contains(err.Error(), "is a directory") {
-			if noColor {
-				fmt.Printf("%s: %s\n", filename, "is a directory")
-			} else {
-				fmt.Printf("\033[1;31m%s: %s\033[0m\n", filename, "is a directory")
-			}
-		} else {
-			if noColor {
-				fmt.Printf("%s: %s\n", filename, err)
-			} else {
-				fmt.Printf("\033[1;31m%s: %s\033[0m\n", filename, err)
-			}
+// runDiff compares two ELF files and returns the exit code, which is 0 if
+// the files are the same, 1 if there are differences and 2 if there was an
+// error, like for diff
+func runDiff(oldArg, newArg, format string, noColor bool) int {
+	var names []string
+	for _, arg := range []string{oldArg, newArg} {
+		filepath, err := which(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
 		}
-		os.Exit(1)
+		names = append(names, filepath)
 	}
-	defer f.Close()
-
-	if onlyCompilerInfo {
-		if noColor {
-			fmt.Printf("%v\n", ainur.Compiler(f))
-		} else {
-			fmt.Printf("\033[1;34m%v\033[0m\n", ainur.Compiler(f))
-		}
-		return
+	d, err := diffFiles(names[0], names[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
 	}
-
-	// Use the short version of LittleEndian and BigEndian
-	byteOrder := strings.Replace(strings.Replace(f.ByteOrder.String(), "LittleEndian", "LE", 1), "BigEndian", "BE", 1)
-
-	fmt.Printf("%s: stripped=%v, compiler=%v, static=%v, byteorder=%v, machine=%v\n", filename, ainur.Stripped(f), ainur.Compiler(f), ainur.Static(f), byteOrder, ainur.Describe(f.Machine))
+	if err := printDiff(os.Stdout, d, format, noColor); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(d.Changes) > 0 {
+		return 1
+	}
+	return 0
 }
 
 func main() {
@@ -103,14 +113,202 @@ func main() {
 		os.Exit(0)
 	}
 
-	filepath, err := which(arguments["<ELF>"].(string))
+	// Respect the NO_COLOR environment variable
+	noColor := os.Getenv("NO_COLOR") != "" || !arguments["--color"].(bool)
+
+	// Register the extra compiler signatures before any file is examined
+	if signaturesFile, ok := arguments["--signatures"].(string); ok {
+		if err := loadSignatures(signaturesFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if arguments["diff"].(bool) {
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
+	}
+
+	if arguments["diff"].(bool) {
+		os.Exit(runDiff(arguments["<old>"].(string), arguments["<new>"].(string), arguments["--format"].(string), noColor))
+	}
+
+	// Resolve each given argument, either as a path or by searching $PATH
+	var paths []string
+	for _, arg := range arguments["<ELF>"].([]string) {
+		// "-" is used for reading from stdin
+		if arg == "-" {
+			paths = append(paths, arg)
+			continue
+		}
+		filepath, err := which(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		paths = append(paths, filepath)
+	}
+
+	opts := scanOptions{
+		followSymlinks: arguments["--follow-symlinks"].(bool),
+		oneFileSystem:  arguments["--one-file-system"].(bool),
+	}
+
+	// Only output the filename in the short output if there may be more than one file
+	showFilename := len(paths) > 1 || isDirectory(paths[0])
+
+	rep, err := newReporter(os.Stdout, arguments["--format"].(string), arguments["--long"].(bool), noColor, showFilename)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	// Respect the NO_COLOR environment variable
-	noColor := os.Getenv("NO_COLOR") != ""
+	examineOpts := examineOptions{
+		allCompilers: arguments["--all"].(bool),
+		comments:     arguments["--comments"].(bool),
+		security:     arguments["--security"].(bool),
+		deps:         arguments["--deps"].(bool),
+		resolve:      arguments["--resolve"].(bool),
+		sysroot:      arguments["--sysroot"].(string),
+		findDebug:    arguments["--find-debug"].(bool),
+		debugRoot:    arguments["--debug-root"].(string),
+		sections:     arguments["--sections"].(bool),
+		segments:     arguments["--segments"].(bool),
+		size:         arguments["--size"].(bool),
+	}
+	if examineOpts.size {
+		top, err := strconv.Atoi(arguments["--top"].(string))
+		if err != nil || top < 0 {
+			fmt.Fprintln(os.Stderr, "invalid number of symbols: "+arguments["--top"].(string))
+			os.Exit(1)
+		}
+		examineOpts.topSymbols = top
+	}
+	rep.findDebug = examineOpts.findDebug
+	rep.comments = examineOpts.comments
+	rep.security = examineOpts.security
+	rep.deps = examineOpts.deps || examineOpts.resolve
+	rep.layout = examineOpts.sections || examineOpts.segments
+	rep.size = examineOpts.size
 
-	examine(filepath, !arguments["--long"].(bool), noColor || !arguments["--color"].(bool))
+	// The policy rules may check the hardening features and the dependencies
+	var pol *policy
+	if policyFile, ok := arguments["--policy"].(string); ok {
+		pol, err = loadPolicy(policyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitPolicyError)
+		}
+		examineOpts.security = true
+		examineOpts.deps = true
+		rep.policy = true
+	}
+	violated := make(map[string]bool)
+
+	// The SBOM lists the checksums and the dynamic dependencies, and is
+	// written to stdout, so errors are written to stderr
+	if sbomFormat, ok := arguments["--sbom"].(string); ok {
+		if sbomFormat != sbomCycloneDX && sbomFormat != sbomSPDX {
+			fmt.Fprintln(os.Stderr, "unknown SBOM format: "+sbomFormat)
+			os.Exit(1)
+		}
+		examineOpts.hashes = true
+		examineOpts.deps = true
+		rep.sbom = sbomFormat
+		rep.errors = os.Stderr
+	}
+
+	jobs, err := strconv.Atoi(arguments["--jobs"].(string))
+	if err != nil || jobs < 0 {
+		fmt.Fprintln(os.Stderr, "invalid number of jobs: "+arguments["--jobs"].(string))
+		os.Exit(1)
+	}
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+	var prog *progress
+	if arguments["--progress"].(bool) {
+		prog = newProgress(os.Stderr)
+	}
+
+	failed := false
+	image := arguments["--image"].(bool)
+
+	// The files are examined in parallel, but the results are checked and
+	// reported from a single goroutine
+	examineFile := func(filename string, emit func(*result)) {
+		if !image {
+			examine(filename, examineOpts, emit)
+			return
+		}
+		if err := examineImage(filename, examineOpts, emit); err != nil {
+			emit(&result{Filename: filename, Error: err.Error(), err: err})
+		}
+	}
+	output := func(j *job) {
+		for _, res := range j.results {
+			if res.err != nil {
+				// Skip non-ELF files quietly, unless they were given explicitly
+				if res.err == errNotELF && !j.explicit {
+					continue
+				}
+				failed = true
+			}
+			if pol != nil && res.err == nil && res.Archive == nil && res.Core == nil {
+				res.Violations = pol.check(res)
+				if res.Violations == nil {
+					res.Violations = []violation{}
+				}
+				for _, v := range res.Violations {
+					violated[v.Rule] = true
+				}
+			}
+			if err := rep.report(res); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+	// The results for --resolve and --find-debug depend on other files, so they are not cached
+	cacheDir, useCache := arguments["--cache-dir"].(string)
+	useCache = useCache || arguments["--cache"].(bool)
+	if useCache && !examineOpts.resolve && !examineOpts.findDebug {
+		if cacheDir == "" {
+			if cacheDir, err = defaultCacheDir(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		cache, err := newResultCache(cacheDir, examineOpts, image)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		examineUncached := examineFile
+		examineFile = func(filename string, emit func(*result)) {
+			cache.examine(filename, examineUncached, emit)
+		}
+	}
+
+	p := newPool(jobs, arguments["--unordered"].(bool), prog, examineFile, output)
+	walkErr := walk(paths, opts, p.submit)
+	p.wait()
+	if prog != nil {
+		prog.finish()
+	}
+	if walkErr != nil {
+		fmt.Fprintln(os.Stderr, walkErr)
+		failed = true
+	}
+	if err := rep.finish(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+	// Policy violations have their own exit codes
+	if pol != nil {
+		if code := pol.exitCode(violated); code != 0 {
+			os.Exit(code)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
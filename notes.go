@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+)
+
+// elfNote is a single entry in an ELF note section or segment
+type elfNote struct {
+	Name string
+	Type uint32
+	Desc []byte
+}
+
+// parseNotes parses the notes in the given data. align is the alignment of
+// the descriptors, which is 4 for most notes and 8 for GNU property notes
+// in 64-bit files. Other alignments, which come from the section or segment
+// header and can be anything in a malformed file, are treated as 4.
+func parseNotes(f *elf.File, data []byte, align uint64) []elfNote {
+	if align != 8 {
+		align = 4
+	}
+	alignUp := func(n uint64) uint64 {
+		return (n + align - 1) &^ (align - 1)
+	}
+	var notes []elfNote
+	for uint64(len(data)) >= 12 {
+		nameSize := uint64(f.ByteOrder.Uint32(data))
+		descSize := uint64(f.ByteOrder.Uint32(data[4:]))
+		noteType := f.ByteOrder.Uint32(data[8:])
+		nameEnd := 12 + nameSize
+		descStart := alignUp(nameEnd)
+		descEnd := descStart + descSize
+		if nameEnd > uint64(len(data)) || descStart < nameEnd || descEnd > uint64(len(data)) || descStart > descEnd {
+			break
+		}
+		name := string(bytes.TrimRight(data[12:nameEnd], "\x00"))
+		notes = append(notes, elfNote{Name: name, Type: noteType, Desc: data[descStart:descEnd]})
+		next := alignUp(descEnd)
+		if next > uint64(len(data)) {
+			break
+		}
+		data = data[next:]
+	}
+	return notes
+}
+
+// readNotes returns every note in the file, from the SHT_NOTE sections if
+// there are any, or from the PT_NOTE segments if not
+func readNotes(f *elf.File) []elfNote {
+	var notes []elfNote
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_NOTE {
+			continue
+		}
+		if data, err := metadataSection(sec); err == nil {
+			notes = append(notes, parseNotes(f, data, sec.Addralign)...)
+		}
+	}
+	if len(f.Sections) > 0 && notes != nil {
+		return notes
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		if data, err := segmentData(prog); err == nil {
+			notes = append(notes, parseNotes(f, data, prog.Align)...)
+		}
+	}
+	return notes
+}
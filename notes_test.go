@@ -0,0 +1,57 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// note encodes a note with the given alignment, like it is in a note section
+func note(name string, typ uint32, desc []byte, align int) []byte {
+	pad := func(b []byte) []byte {
+		for len(b)%align != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b, uint32(len(name)+1))
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(desc)))
+	binary.LittleEndian.PutUint32(b[8:], typ)
+	b = pad(append(b, name+"\x00"...))
+	return pad(append(b, desc...))
+}
+
+func TestParseNotes(t *testing.T) {
+	f := &elf.File{FileHeader: elf.FileHeader{ByteOrder: binary.LittleEndian}}
+	buildID := []byte{0xde, 0xad, 0xbe, 0xef, 0x01}
+	property := []byte{2, 0, 0, 0xc0, 4, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0}
+	// A note with a name size that is larger than the data
+	oversized := note("GNU", 3, buildID, 4)
+	binary.LittleEndian.PutUint32(oversized, 1000)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		align uint64
+		want  []elfNote
+	}{
+		{"build-id", note("GNU", 3, buildID, 4), 4, []elfNote{{"GNU", 3, buildID}}},
+		{"two notes", append(note("GNU", 3, buildID, 4), note("Go", 4, []byte("abc"), 4)...), 4, []elfNote{{"GNU", 3, buildID}, {"Go", 4, []byte("abc")}}},
+		{"aligned to 8", note("GNU", 5, property, 8), 8, []elfNote{{"GNU", 5, property}}},
+		{"alignment 0", note("GNU", 3, buildID, 4), 0, []elfNote{{"GNU", 3, buildID}}},
+		{"huge alignment", note("GNU", 3, buildID, 4), 0xffffffffffffffff, []elfNote{{"GNU", 3, buildID}}},
+		{"odd alignment", note("GNU", 3, buildID, 4), 3, []elfNote{{"GNU", 3, buildID}}},
+		{"name too large", oversized, 4, nil},
+		{"name too large, huge alignment", oversized, 0xffffffffffffffff, nil},
+		{"truncated descriptor", note("GNU", 3, buildID, 4)[:18], 4, nil},
+		{"truncated header", note("GNU", 3, buildID, 4)[:11], 4, nil},
+		{"empty", nil, 4, nil},
+	}
+	for _, test := range tests {
+		if got := parseNotes(f, test.data, test.align); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
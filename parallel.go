@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slotsPerWorker is the number of files per worker that may be examined or
+// waiting to be output at the same time. This limits the memory that is
+// used for results that wait for a slow file, when the output is ordered.
+const slotsPerWorker = 64
+
+// job is a file to examine, and the results of examining it
+type job struct {
+	seq      int // the order the file was found in
+	filename string
+	explicit bool // the file was given directly, and not found in a directory
+	results  []*result
+}
+
+// pool examines files with a number of worker goroutines. The results are
+// passed to output from a single goroutine, either in the order the files
+// were submitted, or as soon as they are ready if unordered is set.
+type pool struct {
+	examine   func(filename string, emit func(*result))
+	output    func(j *job)
+	unordered bool
+	progress  *progress // may be nil
+	jobs      chan *job
+	done      chan *job
+	slots     chan struct{}
+	workers   sync.WaitGroup
+	collected chan struct{}
+	seq       int
+}
+
+// newPool starts the given number of workers, which call examine for each
+// submitted file, and the goroutine that calls output
+func newPool(workers int, unordered bool, prog *progress, examine func(string, func(*result)), output func(*job)) *pool {
+	p := &pool{
+		examine:   examine,
+		output:    output,
+		unordered: unordered,
+		progress:  prog,
+		jobs:      make(chan *job),
+		done:      make(chan *job, workers),
+		slots:     make(chan struct{}, workers*slotsPerWorker),
+		collected: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	go p.collect()
+	return p
+}
+
+// work examines the files from the jobs channel
+func (p *pool) work() {
+	defer p.workers.Done()
+	for j := range p.jobs {
+		p.run(j)
+		if p.progress != nil {
+			p.progress.add(j.filename)
+		}
+		p.done <- j
+	}
+}
+
+// run examines the file of a job. A panic, for example for a malformed
+// file, is turned into an error result for the file, so that the other
+// files are still examined.
+func (p *pool) run(j *job) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic: %v", r)
+			j.results = append(j.results, &result{Filename: j.filename, Error: err.Error(), err: err})
+		}
+	}()
+	p.examine(j.filename, func(res *result) {
+		j.results = append(j.results, res)
+	})
+}
+
+// collect outputs the examined files, in order unless p.unordered is set
+func (p *pool) collect() {
+	defer close(p.collected)
+	pending := make(map[int]*job)
+	next := 0
+	for j := range p.done {
+		if p.unordered {
+			p.output(j)
+			<-p.slots
+			continue
+		}
+		pending[j.seq] = j
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			p.output(ready)
+			<-p.slots
+			next++
+		}
+	}
+}
+
+// submit queues a file to be examined, and blocks while too many files are
+// being examined or are waiting to be output
+func (p *pool) submit(filename string, explicit bool) {
+	p.slots <- struct{}{}
+	p.jobs <- &job{seq: p.seq, filename: filename, explicit: explicit}
+	p.seq++
+}
+
+// wait waits until all the submitted files have been examined and output
+func (p *pool) wait() {
+	close(p.jobs)
+	p.workers.Wait()
+	close(p.done)
+	<-p.collected
+}
+
+// progress counts the examined files and bytes, and writes the throughput
+// to w at regular intervals
+type progress struct {
+	w     io.Writer
+	files int64 // updated atomically
+	bytes int64 // updated atomically
+	start time.Time
+	stop  chan struct{}
+	ended chan struct{}
+}
+
+// progressInterval is how often the progress is written
+const progressInterval = 500 * time.Millisecond
+
+// newProgress starts writing the progress to w
+func newProgress(w io.Writer) *progress {
+	prog := &progress{w: w, start: time.Now(), stop: make(chan struct{}), ended: make(chan struct{})}
+	go func() {
+		defer close(prog.ended)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				prog.print()
+			case <-prog.stop:
+				prog.print()
+				fmt.Fprintln(prog.w)
+				return
+			}
+		}
+	}()
+	return prog
+}
+
+// add counts an examined file
+func (prog *progress) add(filename string) {
+	atomic.AddInt64(&prog.files, 1)
+	if filename == "-" {
+		return
+	}
+	if fi, err := os.Stat(filename); err == nil {
+		atomic.AddInt64(&prog.bytes, fi.Size())
+	}
+}
+
+// print writes the number of examined files and bytes, and the throughput,
+// over the previous line
+func (prog *progress) print() {
+	files, bytes := atomic.LoadInt64(&prog.files), atomic.LoadInt64(&prog.bytes)
+	seconds := time.Since(prog.start).Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	const mib = 1024 * 1024
+	// Trailing spaces overwrite the end of a longer previous line
+	fmt.Fprintf(prog.w, "\r%d files, %.1f MiB, %.0f files/s, %.1f MiB/s, %s   ", files, float64(bytes)/mib, float64(files)/seconds, float64(bytes)/mib/seconds, time.Since(prog.start).Round(time.Second))
+}
+
+// finish writes the final progress and stops writing more
+func (prog *progress) finish() {
+	close(prog.stop)
+	<-prog.ended
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// Exit codes for the policy mode. Each rule can have its own exit code,
+// which must be in the range from exitPolicyViolation to maxPolicyExitCode.
+const (
+	exitPolicyError     = 2 // the policy file could not be used
+	exitPolicyViolation = 3 // the default exit code for rules that are violated
+	maxPolicyExitCode   = 125
+)
+
+// policyCheck is a condition on one property of a file, like "pie: true".
+// The condition holds if any of the values match.
+type policyCheck struct {
+	Key    string
+	Values []string
+}
+
+// policyRule is a rule in a policy file. The rule applies to the files
+// where all the "when" checks hold. For those files, all the "require"
+// checks must hold, and the "forbid" checks must not all hold.
+type policyRule struct {
+	Name        string
+	Description string
+	When        []policyCheck
+	Require     []policyCheck
+	Forbid      []policyCheck
+	ExitCode    int
+}
+
+// policy is a list of rules, which are loaded from a YAML or JSON file
+type policy struct {
+	Rules []*policyRule
+}
+
+// violation is a rule that a file does not comply with
+type violation struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description,omitempty"`
+	Check       string `json:"check"` // like "pie", or "compiler, compiler_version" for forbid rules
+	Want        string `json:"want"`
+	Got         string `json:"got"`
+	ExitCode    int    `json:"exit_code"`
+}
+
+// policyCheckDef describes how a property is found and matched
+type policyCheckDef struct {
+	values func(res *result) []string       // the values of the file, where any of them may match
+	match  func(pattern, value string) bool // checks if a value from the file matches a value from the policy
+}
+
+// matchGlob matches a value with a shell pattern, case insensitively
+func matchGlob(pattern, value string) bool {
+	ok, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && ok
+}
+
+// matchBool matches "true" or "false", also written as "yes" or "no"
+func matchBool(pattern, value string) bool {
+	switch strings.ToLower(pattern) {
+	case "true", "yes", "on":
+		return value == "true"
+	case "false", "no", "off":
+		return value == "false"
+	}
+	return false
+}
+
+// matchCompiler matches the compiler name, like "GCC", or the compiler
+// name and version with a shell pattern, like "GCC 12.*"
+func matchCompiler(pattern, value string) bool {
+	lower := strings.ToLower(value)
+	name := strings.ToLower(pattern)
+	return lower == name || strings.HasPrefix(lower, name+" ") || matchGlob(pattern, value)
+}
+
+// versionOperators are the operators that can be used for comparing versions,
+// with the longest ones first
+var versionOperators = []string{">=", "<=", "==", "!=", ">", "<", "="}
+
+// matchVersion compares a version with a condition like ">= 11" or "< 1.20".
+// A version without an operator, like "12", matches 12 and 12.x.
+func matchVersion(pattern, value string) bool {
+	pattern = strings.TrimSpace(pattern)
+	op := ""
+	for _, candidate := range versionOperators {
+		if strings.HasPrefix(pattern, candidate) {
+			op = candidate
+			pattern = strings.TrimSpace(pattern[len(candidate):])
+			break
+		}
+	}
+	if op == "" {
+		return value == pattern || strings.HasPrefix(value, pattern+".")
+	}
+	have, want := compiler.ParseSemVer(value), compiler.ParseSemVer(pattern)
+	if have == nil || want == nil {
+		return false
+	}
+	cmp := have.Compare(want)
+	switch op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+// matchPath matches a path with a shell pattern, where "*" also matches
+// "/", so that "*/debug/*" matches any file in a debug directory. Patterns
+// without a "/" are matched with the base name.
+func matchPath(pattern, value string) bool {
+	if !strings.Contains(pattern, "/") {
+		value = path.Base(value)
+	}
+	expr := strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(pattern))
+	ok, err := regexp.MatchString("^"+expr+"$", value)
+	return err == nil && ok
+}
+
+// boolValue returns a property that is a bool as a list with one value
+func boolValue(get func(res *result) bool) func(res *result) []string {
+	return func(res *result) []string {
+		return []string{strconv.FormatBool(get(res))}
+	}
+}
+
+// pieValue returns "true" for position independent executables and shared
+// libraries, or "n/a" for object files, where PIE does not apply
+func pieValue(s *securityInfo) string {
+	if s.Relocatable {
+		return "n/a"
+	}
+	return strconv.FormatBool(s.PIE || s.SharedObject)
+}
+
+// securityValue returns a hardening property as a list with one value,
+// or no values if the hardening features were not examined
+func securityValue(get func(s *securityInfo) string) func(res *result) []string {
+	return func(res *result) []string {
+		if res.Security == nil {
+			return nil
+		}
+		return []string{get(res.Security)}
+	}
+}
+
+// policyChecks are the properties that can be used in policy rules
+var policyChecks = map[string]policyCheckDef{
+	"path":     {func(res *result) []string { return []string{res.Filename} }, matchPath},
+	"compiler": {func(res *result) []string { return []string{res.Compiler} }, matchCompiler},
+	"compiler_version": {func(res *result) []string {
+		if res.CompilerVersion == "" {
+			return nil
+		}
+		return []string{res.CompilerVersion}
+	}, matchVersion},
+	"go_version": {func(res *result) []string {
+		if res.GoBuildInfo == nil {
+			return nil
+		}
+		return []string{strings.TrimPrefix(res.GoBuildInfo.GoVersion, "go")}
+	}, matchVersion},
+	"stripped": {boolValue(func(res *result) bool { return res.Stripped }), matchBool},
+	"static":   {boolValue(func(res *result) bool { return res.Static }), matchBool},
+	"machine":  {func(res *result) []string { return []string{res.Machine} }, matchGlob},
+	"class":    {func(res *result) []string { return []string{res.Class} }, matchGlob},
+	"relro":    {securityValue(func(s *securityInfo) string { return s.RELRO }), matchGlob},
+	"nx":       {securityValue(func(s *securityInfo) string { return strconv.FormatBool(s.NX) }), matchBool},
+	"pie":      {securityValue(pieValue), matchBool},
+	"canary":   {securityValue(func(s *securityInfo) string { return strconv.FormatBool(s.Canary) }), matchBool},
+	"fortify":  {securityValue(func(s *securityInfo) string { return strconv.FormatBool(s.Fortify) }), matchBool},
+	"ibt":      {securityValue(func(s *securityInfo) string { return strconv.FormatBool(s.IBT) }), matchBool},
+	"shstk":    {securityValue(func(s *securityInfo) string { return strconv.FormatBool(s.SHSTK) }), matchBool},
+	"needed": {func(res *result) []string {
+		if res.Deps == nil {
+			return nil
+		}
+		return res.Deps.Needed
+	}, matchGlob},
+	"rpath": {func(res *result) []string {
+		if res.Deps == nil {
+			return nil
+		}
+		return res.Deps.RPATH
+	}, matchGlob},
+	"runpath": {func(res *result) []string {
+		if res.Deps == nil {
+			return nil
+		}
+		return res.Deps.RUNPATH
+	}, matchGlob},
+}
+
+// holds checks if any value of the file matches any value of the check
+func (c policyCheck) holds(res *result) bool {
+	def := policyChecks[c.Key]
+	for _, value := range def.values(res) {
+		for _, pattern := range c.Values {
+			if def.match(pattern, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// got returns the values of the file, for reporting violations
+func (c policyCheck) got(res *result) string {
+	values := policyChecks[c.Key].values(res)
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// matching returns the values of the file that match the check
+func (c policyCheck) matching(res *result) string {
+	def := policyChecks[c.Key]
+	var values []string
+	for _, value := range def.values(res) {
+		for _, pattern := range c.Values {
+			if def.match(pattern, value) {
+				values = append(values, value)
+				break
+			}
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// want returns the values of the check, for reporting violations
+func (c policyCheck) want() string {
+	return strings.Join(c.Values, " or ")
+}
+
+// check returns the rules that the given file violates
+func (p *policy) check(res *result) []violation {
+	var violations []violation
+	for _, rule := range p.Rules {
+		applies := true
+		for _, c := range rule.When {
+			if !c.holds(res) {
+				applies = false
+				break
+			}
+		}
+		if !applies {
+			continue
+		}
+		for _, c := range rule.Require {
+			if !c.holds(res) {
+				violations = append(violations, violation{rule.Name, rule.Description, c.Key, c.want(), c.got(res), rule.ExitCode})
+			}
+		}
+		if len(rule.Forbid) == 0 {
+			continue
+		}
+		var keys, wants, gots []string
+		forbidden := true
+		for _, c := range rule.Forbid {
+			if !c.holds(res) {
+				forbidden = false
+				break
+			}
+			keys = append(keys, c.Key)
+			wants = append(wants, "not "+c.want())
+			gots = append(gots, c.matching(res))
+		}
+		if forbidden {
+			violations = append(violations, violation{rule.Name, rule.Description, strings.Join(keys, ", "), strings.Join(wants, ", "), strings.Join(gots, "; "), rule.ExitCode})
+		}
+	}
+	return violations
+}
+
+// exitCode returns the exit code of the first rule in the policy that was
+// violated, or 0 if no rules were violated
+func (p *policy) exitCode(violated map[string]bool) int {
+	for _, rule := range p.Rules {
+		if violated[rule.Name] {
+			return rule.ExitCode
+		}
+	}
+	return 0
+}
+
+// toStrings converts a scalar or a list of scalars from a policy file to strings
+func toStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			itemValues, err := toStrings(item)
+			if err != nil || len(itemValues) != 1 {
+				return nil, errors.New("expected a value or a list of values")
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	}
+	return nil, errors.New("expected a value or a list of values")
+}
+
+// parseChecks converts a mapping like {"pie": true, "relro": "full"} to checks
+func parseChecks(value interface{}) ([]policyCheck, error) {
+	mapping, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected a mapping of checks")
+	}
+	// Sort the checks, for a predictable order of the violations
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	checks := make([]policyCheck, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := policyChecks[key]; !ok {
+			return nil, fmt.Errorf("unknown check: %s", key)
+		}
+		values, err := toStrings(mapping[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		checks = append(checks, policyCheck{Key: key, Values: values})
+	}
+	return checks, nil
+}
+
+// parseRule converts a mapping from a policy file to a rule
+func parseRule(value interface{}) (*policyRule, error) {
+	mapping, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected a mapping")
+	}
+	rule := &policyRule{ExitCode: exitPolicyViolation}
+	for key, v := range mapping {
+		var err error
+		switch key {
+		case "name", "description", "exit_code":
+			var values []string
+			if values, err = toStrings(v); err == nil && len(values) != 1 {
+				err = errors.New("expected a single value")
+			}
+			if err != nil {
+				break
+			}
+			switch key {
+			case "name":
+				rule.Name = values[0]
+			case "description":
+				rule.Description = values[0]
+			case "exit_code":
+				rule.ExitCode, err = strconv.Atoi(values[0])
+				if err == nil && (rule.ExitCode < exitPolicyViolation || rule.ExitCode > maxPolicyExitCode) {
+					err = fmt.Errorf("must be from %d to %d", exitPolicyViolation, maxPolicyExitCode)
+				}
+			}
+		case "when":
+			rule.When, err = parseChecks(v)
+		case "require":
+			rule.Require, err = parseChecks(v)
+		case "forbid":
+			rule.Forbid, err = parseChecks(v)
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	if rule.Name == "" {
+		return nil, errors.New("a rule needs a name")
+	}
+	if len(rule.Require) == 0 && len(rule.Forbid) == 0 {
+		return nil, fmt.Errorf("%s: a rule needs require or forbid checks", rule.Name)
+	}
+	return rule, nil
+}
+
+// loadPolicy reads a policy file, in YAML or JSON. The rules are either the
+// top level list, or the list in the "rules" field.
+func loadPolicy(filename string) (*policy, error) {
+	items, err := loadList(filename, "rules")
+	if err != nil {
+		return nil, err
+	}
+	p := &policy{}
+	for i, item := range items {
+		rule, err := parseRule(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %v", filename, i+1, err)
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	return p, nil
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// result is the outcome of examining a single file. The JSON field names
+// are part of the documented output schema and should be kept stable.
+type result struct {
+	Filename        string           `json:"filename"`
+	Compiler        string           `json:"compiler"`
+	CompilerName    string           `json:"compiler_name"`
+	CompilerVersion string           `json:"compiler_version"`
+	CompilerInfo    *compiler.Info   `json:"compiler_info,omitempty"`
+	Compilers       []*compiler.Info `json:"compilers,omitempty"`
+	Stripped        bool             `json:"stripped"`
+	Static          bool             `json:"static"`
+	ByteOrder       string           `json:"byte_order"`
+	Machine         string           `json:"machine"`
+	Class           string           `json:"class"`
+	Error           string           `json:"error,omitempty"`
+
+	Comments []compiler.Producer `json:"comments,omitempty"`
+	Security *securityInfo       `json:"security,omitempty"`
+	Deps     *depsInfo           `json:"deps,omitempty"`
+	Debug    *debugInfo          `json:"debug,omitempty"`
+
+	// Archive is only set for the summary of an archive, like a static
+	// library, which is reported after the members
+	Archive     *archiveSummary       `json:"archive,omitempty"`
+	GoBuildInfo *compiler.GoBuildInfo `json:"go_build_info,omitempty"`
+
+	Sections []sectionEntry `json:"sections,omitempty"`
+	Segments []segmentEntry `json:"segments,omitempty"`
+	Size     *sizeReport    `json:"size,omitempty"`
+
+	// Violations is only set when checking files against a policy
+	Violations []violation `json:"violations,omitempty"`
+
+	// Core is only set for core files
+	Core *coreInfo `json:"core,omitempty"`
+
+	// Layer is the digest of the container image layer the file came from
+	Layer string `json:"layer,omitempty"`
+
+	// Hashes is only set when writing an SBOM, which is not in the JSON output
+	Hashes *hashInfo `json:"-"`
+	// RustPackages is the list of crates embedded by cargo-auditable
+	RustPackages []rustPackage `json:"rust_packages,omitempty"`
+
+	err       error // the error that Error was set from, if any
+	inArchive bool  // the file was found within an archive or compressed stream
+}
+
+// Output formats
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// reporter outputs results in the selected format
+type reporter struct {
+	w            io.Writer
+	format       string
+	long         bool // output more than just the compiler, for the text format
+	noColor      bool
+	showFilename bool      // prefix the short text output with the filename
+	comments     bool      // list the .comment entries, for the text format
+	security     bool      // output the hardening features, for the text format
+	deps         bool      // output the dynamic dependencies, for the text format
+	findDebug    bool      // output the debug file search results, for the text format
+	layout       bool      // output the section and program headers, for the text format
+	size         bool      // output the size report, for the text format
+	policy       bool      // output the policy violations, for the text format
+	sbom         string    // write an SBOM in this format instead, if set
+	errors       io.Writer // where errors are output, which is w unless an SBOM is written
+	results      []*result
+}
+
+// newReporter creates a new reporter for the given output format
+func newReporter(w io.Writer, format string, long, noColor, showFilename bool) (*reporter, error) {
+	switch format {
+	case formatText, formatJSON, formatNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+	return &reporter{w: w, format: format, long: long, noColor: noColor, showFilename: showFilename, errors: w}, nil
+}
+
+// report outputs a single result, or collects it if the output format
+// requires all results to be known before anything can be written
+func (r *reporter) report(res *result) error {
+	if r.sbom != "" {
+		if res.err != nil {
+			r.printError(res.Filename, res.err)
+		}
+		r.results = append(r.results, res)
+		return nil
+	}
+	switch r.format {
+	case formatJSON:
+		r.results = append(r.results, res)
+		return nil
+	case formatNDJSON:
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(r.w, "%s\n", data)
+		return err
+	}
+	if res.err != nil {
+		r.printError(res.Filename, res.err)
+		return nil
+	}
+	if res.Archive != nil {
+		return r.printArchive(res.Filename, res.Archive)
+	}
+	if r.policy && res.Violations != nil {
+		return r.printViolations(res)
+	}
+	// Each requested view is output in turn, after the compiler, or the
+	// crashed process for core files. That is only left out if a view was
+	// requested without -l or --all.
+	var views []func() error
+	if r.comments && res.Core == nil {
+		views = append(views, func() error { return r.printComments(res) })
+	}
+	if r.layout {
+		views = append(views, func() error { return r.printLayout(res) })
+	}
+	if r.size && res.Size != nil {
+		views = append(views, func() error { return r.printSize(res.Filename, res.Size) })
+	}
+	if r.security && res.Security != nil {
+		views = append(views, func() error { return r.printSecurity(res.Filename, res.Security) })
+	}
+	if r.deps && res.Deps != nil {
+		views = append(views, func() error { return r.printDeps(res.Filename, res.Deps) })
+	}
+	if r.findDebug && res.Debug != nil {
+		views = append(views, func() error { return r.printDebug(res.Filename, res.Debug) })
+	}
+	if len(views) == 0 || r.long || res.Compilers != nil {
+		print := r.printCompiler
+		if res.Core != nil {
+			print = r.printCore
+		}
+		if err := print(res); err != nil {
+			return err
+		}
+	}
+	for _, view := range views {
+		if err := view(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printCompiler outputs the detected compiler, or every detected compiler
+// with --all. The long output also has the stripped status, byte order,
+// target machine and debug links, followed by the Go build info, if any.
+func (r *reporter) printCompiler(res *result) error {
+	compilers := res.Compiler
+	if res.Compilers != nil {
+		compilers = joinCompilers(res.Compilers, r.long)
+	}
+	if r.long {
+		confidence := "none"
+		if res.CompilerInfo != nil {
+			confidence = res.CompilerInfo.Confidence
+		}
+		buildID, debugLink := "none", "none"
+		if res.Debug != nil {
+			buildID, debugLink = orNone(res.Debug.BuildID), orNone(res.Debug.DebugLink)
+		}
+		layer := ""
+		if res.Layer != "" {
+			layer = ", layer=" + res.Layer
+		}
+		if _, err := fmt.Fprintf(r.w, "%s: stripped=%v, compiler=%v, static=%v, byteorder=%v, machine=%v, confidence=%v, buildid=%v, debuglink=%v%s\n", res.Filename, res.Stripped, compilers, res.Static, res.ByteOrder, res.Machine, confidence, buildID, debugLink, layer); err != nil {
+			return err
+		}
+		if res.GoBuildInfo != nil {
+			return r.printGoBuildInfo(res.GoBuildInfo)
+		}
+		return nil
+	}
+	prefix := ""
+	if r.showFilename || res.inArchive {
+		prefix = res.Filename + ": "
+		if res.Layer != "" {
+			prefix = res.Filename + " (layer " + shortDigest(res.Layer) + "): "
+		}
+	}
+	if r.noColor {
+		_, err := fmt.Fprintf(r.w, "%s%v\n", prefix, compilers)
+		return err
+	}
+	_, err := fmt.Fprintf(r.w, "%s\033[1;34m%v\033[0m\n", prefix, compilers)
+	return err
+}
+
+// joinCompilers returns the given compilers as a comma separated string,
+// or "unknown" if the slice is empty. If withConfidence is true, the
+// confidence level is added after each compiler, like "TCC [low]".
+func joinCompilers(infos []*compiler.Info, withConfidence bool) string {
+	if len(infos) == 0 {
+		return "unknown"
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.String()
+		if withConfidence {
+			names[i] += " [" + info.Confidence + "]"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// printComments outputs the filename, followed by one indented line per
+// .comment entry, with the parsed producer and version
+func (r *reporter) printComments(res *result) error {
+	if _, err := fmt.Fprintf(r.w, "%s:\n", res.Filename); err != nil {
+		return err
+	}
+	for _, p := range res.Comments {
+		name := strings.TrimSpace(p.Name + " " + p.Version)
+		if !r.noColor {
+			name = "\033[1;34m" + name + "\033[0m"
+		}
+		if _, err := fmt.Fprintf(r.w, "  %s: %s\n", name, p.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printGoBuildInfo outputs the Go build info as indented lines, on the same
+// form as "go version -m"
+func (r *reporter) printGoBuildInfo(info *compiler.GoBuildInfo) error {
+	var lines []string
+	if info.Path != "" {
+		lines = append(lines, "path\t"+info.Path)
+	}
+	if info.Main != nil {
+		lines = append(lines, "mod\t"+info.Main.String())
+	}
+	for _, dep := range info.Deps {
+		lines = append(lines, "dep\t"+dep.String())
+	}
+	for _, setting := range info.Settings {
+		lines = append(lines, "build\t"+setting.Key+"="+setting.Value)
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(r.w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSecurity outputs the hardening features on a single line
+func (r *reporter) printSecurity(filename string, info *securityInfo) error {
+	pathList := func(paths []string) string {
+		if len(paths) == 0 {
+			return "none"
+		}
+		return strings.Join(paths, ":")
+	}
+	pie := fmt.Sprintf("%v", info.PIE)
+	if info.SharedObject {
+		pie = "dso"
+	}
+	if info.Relocatable {
+		pie = "n/a"
+	}
+	_, err := fmt.Fprintf(r.w, "%s: relro=%s, nx=%v, pie=%s, canary=%v, fortify=%v, ibt=%v, shstk=%v, rpath=%s, runpath=%s\n", filename, info.RELRO, info.NX, pie, info.Canary, info.Fortify, info.IBT, info.SHSTK, pathList(info.RPATH), pathList(info.RUNPATH))
+	return err
+}
+
+// printDeps outputs the filename, followed by one indented line per
+// dependency, interpreter, soname or search path. Resolved libraries are
+// listed like ldd does.
+func (r *reporter) printDeps(filename string, deps *depsInfo) error {
+	lines := []string{filename + ":"}
+	if deps.Interpreter != "" {
+		lines = append(lines, "  interpreter: "+deps.Interpreter)
+	}
+	if deps.SONAME != "" {
+		lines = append(lines, "  soname: "+deps.SONAME)
+	}
+	for _, path := range deps.RPATH {
+		lines = append(lines, "  rpath: "+path)
+	}
+	for _, path := range deps.RUNPATH {
+		lines = append(lines, "  runpath: "+path)
+	}
+	if deps.Resolved == nil {
+		for _, name := range deps.Needed {
+			lines = append(lines, "  needed: "+name)
+		}
+	}
+	for _, lib := range deps.Resolved {
+		path := lib.Path
+		if path == "" {
+			path = "not found"
+			if !r.noColor {
+				path = "\033[1;31m" + path + "\033[0m"
+			}
+		}
+		lines = append(lines, "  "+lib.Name+" => "+path)
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(r.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orNone returns the given string, or "none" if it is empty
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// printDebug outputs the build-id, debuglink and the debug file search
+// results on a single line
+func (r *reporter) printDebug(filename string, info *debugInfo) error {
+	debugFile := info.DebugFile
+	if debugFile == "" {
+		debugFile = "not found"
+	}
+	crc := "unchecked"
+	if info.CRCVerified != nil {
+		crc = "mismatch"
+		if *info.CRCVerified {
+			crc = "ok"
+		}
+	}
+	_, err := fmt.Fprintf(r.w, "%s: buildid=%s, debuglink=%s, debugfile=%s, crc=%s\n", filename, orNone(info.BuildID), orNone(info.DebugLink), debugFile, crc)
+	return err
+}
+
+// printArchive outputs the summary of an archive
+func (r *reporter) printArchive(filename string, summary *archiveSummary) error {
+	toolchains := "unknown"
+	if len(summary.Toolchains) > 0 {
+		toolchains = strings.Join(summary.Toolchains, ", ")
+	}
+	if !r.noColor {
+		toolchains = "\033[1;34m" + toolchains + "\033[0m"
+	}
+	_, err := fmt.Fprintf(r.w, "%s: %d of %d members are ELF files, toolchains: %s\n", filename, summary.ELFMembers, summary.Members, toolchains)
+	return err
+}
+
+// formatEntropy formats an entropy value, or "-" if there is none
+func formatEntropy(e *float64) string {
+	if e == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f", *e)
+}
+
+// printViolations outputs one line per policy violation. Files without
+// violations are only listed with the long output.
+func (r *reporter) printViolations(res *result) error {
+	if len(res.Violations) == 0 {
+		if !r.long {
+			return nil
+		}
+		ok := "ok"
+		if !r.noColor {
+			ok = "\033[1;32m" + ok + "\033[0m"
+		}
+		_, err := fmt.Fprintf(r.w, "%s: %s\n", res.Filename, ok)
+		return err
+	}
+	for _, v := range res.Violations {
+		rule := v.Rule
+		if !r.noColor {
+			rule = "\033[1;31m" + rule + "\033[0m"
+		}
+		if _, err := fmt.Fprintf(r.w, "%s: %s: %s: want %s, got %s\n", res.Filename, rule, v.Check, v.Want, v.Got); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printLayout outputs the filename, followed by a table of the section
+// headers and a table of the program headers, if they were listed
+func (r *reporter) printLayout(res *result) error {
+	if _, err := fmt.Fprintf(r.w, "%s:\n", res.Filename); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(r.w, 0, 8, 2, ' ', 0)
+	if res.Sections != nil {
+		fmt.Fprintln(tw, "  Nr\tName\tType\tFlags\tAddress\tOffset\tSize\tAlign\tEntropy")
+		for i, sec := range res.Sections {
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%s\t0x%x\t%d\t%d\t%s\n", i, sec.Name, sec.Type, sec.Flags, sec.Addr, sec.Offset, sec.Size, sec.Align, formatEntropy(sec.Entropy))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if res.Segments != nil {
+		if res.Sections != nil {
+			fmt.Fprintln(r.w)
+		}
+		fmt.Fprintln(tw, "  Type\tFlags\tOffset\tVirtAddr\tPhysAddr\tFileSize\tMemSize\tAlign\tEntropy")
+		for _, prog := range res.Segments {
+			fmt.Fprintf(tw, "  %s\t%s\t0x%x\t%s\t%s\t%d\t%d\t%d\t%s\n", prog.Type, prog.Flags, prog.Offset, prog.Vaddr, prog.Paddr, prog.Filesz, prog.Memsz, prog.Align, formatEntropy(prog.Entropy))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+// printSize outputs the size report as three tables: the sections, the
+// largest symbols and the groups of symbols, with their share of the file size
+func (r *reporter) printSize(filename string, report *sizeReport) error {
+	if _, err := fmt.Fprintf(r.w, "%s: %d bytes\n", filename, report.FileSize); err != nil {
+		return err
+	}
+	share := func(size uint64) string {
+		if report.FileSize == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(size)*100/float64(report.FileSize))
+	}
+	tw := tabwriter.NewWriter(r.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Section\tSize\tShare")
+	for _, sec := range report.Sections {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", sec.Name, sec.Size, share(sec.Size))
+	}
+	fmt.Fprintf(tw, "  (headers and padding)\t%d\t%s\n", report.Other, share(report.Other))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(report.Symbols) > 0 {
+		fmt.Fprintln(r.w)
+		fmt.Fprintln(tw, "  Symbol\tSize\tShare")
+		for _, sym := range report.Symbols {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\n", sym.Name, sym.Size, share(sym.Size))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(report.Groups) > 0 {
+		fmt.Fprintln(r.w)
+		fmt.Fprintln(tw, "  Group\tLanguage\tSize\tShare")
+		for _, group := range report.Groups {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%s\n", group.Name, group.Language, group.Size, share(group.Size))
+		}
+		return tw.Flush()
+	}
+	return nil
+}
+
+// printCore outputs a summary of the crashed process in a core file. With
+// the long output, the threads, mapped files and auxiliary vector follow
+// as indented lines.
+func (r *reporter) printCore(res *result) error {
+	info := res.Core
+	crash := "no signal information"
+	if info.Signal != nil {
+		crash = "killed by " + info.Signal.Name
+		var details []string
+		if info.Signal.CodeName != "" {
+			details = append(details, info.Signal.CodeName)
+		}
+		if info.Signal.Addr != "" {
+			details = append(details, "at "+info.Signal.Addr)
+		}
+		if info.Signal.SenderPID != 0 {
+			details = append(details, fmt.Sprintf("from pid %d", info.Signal.SenderPID))
+		}
+		if len(details) > 0 {
+			crash += " (" + strings.Join(details, " ") + ")"
+		}
+	}
+	program := orNone(info.Program)
+	if info.ExecFn != "" {
+		program = info.ExecFn
+	}
+	if !r.noColor {
+		program = "\033[1;34m" + program + "\033[0m"
+	}
+	if _, err := fmt.Fprintf(r.w, "%s: core dump of %s, pid %d, %s, %d threads, %d mapped files\n", res.Filename, program, info.PID, crash, len(info.Threads), len(info.mappedPaths())); err != nil {
+		return err
+	}
+	if !r.long {
+		return nil
+	}
+	lines := []string{
+		"args: " + info.Args,
+		fmt.Sprintf("ppid=%d, uid=%d, gid=%d, state=%s, byteorder=%s, machine=%s", info.PPID, info.UID, info.GID, orNone(info.State), res.ByteOrder, res.Machine),
+	}
+	for _, thread := range info.Threads {
+		lines = append(lines, fmt.Sprintf("thread %d: signal=%s, pc=%s, sp=%s", thread.TID, orNone(thread.Signal), orNone(thread.PC), orNone(thread.SP)))
+	}
+	// One line per file, with the address of its first mapping
+	listed := make(map[string]bool)
+	for _, file := range info.Files {
+		if listed[file.Path] {
+			continue
+		}
+		listed[file.Path] = true
+		lines = append(lines, fmt.Sprintf("file %s: start=%s, buildid=%s", file.Path, file.Start, orNone(file.BuildID)))
+	}
+	for _, entry := range info.Auxv {
+		lines = append(lines, "auxv "+entry.Name+"="+entry.Value)
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(r.w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shortDigest shortens a digest like "sha256:..." to 12 hex digits, like docker does
+func shortDigest(digest string) string {
+	if pos := strings.IndexByte(digest, ':'); pos != -1 {
+		digest = digest[pos+1:]
+	}
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
+
+// printError outputs an error message for the given filename, in red if
+// colors are enabled
+func (r *reporter) printError(filename string, err error) {
+	color := "1;31"
+	if err == errNotELF {
+		color = "1;33"
+	}
+	if r.noColor {
+		fmt.Fprintf(r.errors, "%s: %s\n", filename, err)
+	} else {
+		fmt.Fprintf(r.errors, "\033[%sm%s: %s\033[0m\n", color, filename, err)
+	}
+}
+
+// finish writes any collected results
+func (r *reporter) finish() error {
+	if r.sbom != "" {
+		return writeSBOM(r.w, r.sbom, r.results)
+	}
+	if r.format != formatJSON {
+		return nil
+	}
+	if r.results == nil {
+		r.results = []*result{}
+	}
+	data, err := json.MarshalIndent(r.results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(r.w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SBOM formats
+const (
+	sbomCycloneDX = "cyclonedx" // CycloneDX 1.5, in JSON
+	sbomSPDX      = "spdx"      // SPDX 2.3, in JSON
+)
+
+// hashInfo is the hex encoded checksums of a file
+type hashInfo struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// fileHashes returns the checksums of all the data in r
+func fileHashes(r io.ReaderAt) (*hashInfo, error) {
+	h1, h256 := sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h256), io.NewSectionReader(r, 0, math.MaxInt64)); err != nil {
+		return nil, err
+	}
+	return &hashInfo{SHA1: hex.EncodeToString(h1.Sum(nil)), SHA256: hex.EncodeToString(h256.Sum(nil))}, nil
+}
+
+// sbomModule is a Go module or Rust crate that is embedded in an executable
+type sbomModule struct {
+	name    string
+	version string
+	purl    string // the package URL, if the module comes from a package registry
+	build   bool   // only used for building, like Rust build scripts and procedural macros
+	direct  bool   // a dependency of the main module. Go modules are all direct, since the Go build info has no dependency graph.
+	deps    []int  // the indexes of the modules this module depends on
+}
+
+// goPURL returns the package URL for a Go module, or an empty string for
+// modules without a released version
+func goPURL(modulePath, version string) string {
+	if version == "" || version == "(devel)" {
+		return ""
+	}
+	return "pkg:golang/" + modulePath + "@" + purlEscape(version)
+}
+
+// cargoPURL returns the package URL for a Rust crate, or an empty string for
+// crates that are not from crates.io
+func cargoPURL(name, version, source string) string {
+	if source != "crates.io" || version == "" {
+		return ""
+	}
+	return "pkg:cargo/" + name + "@" + purlEscape(version)
+}
+
+// purlEscape escapes a version for use in a package URL, where "+" must be
+// escaped too
+func purlEscape(s string) string {
+	return strings.Replace(url.PathEscape(s), "+", "%2B", -1)
+}
+
+// embeddedModules returns the main module and the dependencies that are
+// recorded in a Go or Rust executable, if any
+func embeddedModules(res *result) (*sbomModule, []*sbomModule) {
+	var main *sbomModule
+	var modules []*sbomModule
+	if info := res.GoBuildInfo; info != nil {
+		if info.Main != nil && info.Main.Path != "" {
+			main = &sbomModule{name: info.Main.Path, version: info.Main.Version, purl: goPURL(info.Main.Path, info.Main.Version)}
+		}
+		for _, dep := range info.Deps {
+			// The replacement is what was built
+			m := dep
+			if dep.Replace != nil {
+				m = dep.Replace
+			}
+			modules = append(modules, &sbomModule{name: m.Path, version: m.Version, purl: goPURL(m.Path, m.Version), direct: true})
+		}
+		return main, modules
+	}
+	// The root crate is the main module, and the other crates are renumbered
+	index := make(map[int]int)
+	for i, pkg := range res.RustPackages {
+		if !pkg.Root {
+			index[i] = len(index)
+		}
+	}
+	for i, pkg := range res.RustPackages {
+		m := &sbomModule{name: pkg.Name, version: pkg.Version, purl: cargoPURL(pkg.Name, pkg.Version, pkg.Source), build: pkg.Kind == "build"}
+		for _, dep := range pkg.Dependencies {
+			if j, ok := index[dep]; ok {
+				m.deps = append(m.deps, j)
+			}
+		}
+		if _, ok := index[i]; ok {
+			modules = append(modules, m)
+			continue
+		}
+		main = m
+	}
+	if main != nil {
+		for _, j := range main.deps {
+			modules[j].direct = true
+		}
+	}
+	return main, modules
+}
+
+// sbomSubjects returns the results that describe ELF files that can be
+// listed in an SBOM, which leaves out errors, archive summaries and core files
+func sbomSubjects(results []*result) []*result {
+	var subjects []*result
+	for _, res := range results {
+		if res.err == nil && res.Archive == nil && res.Core == nil {
+			subjects = append(subjects, res)
+		}
+	}
+	return subjects
+}
+
+// isLibrary checks if an examined ELF file is a shared library
+func isLibrary(res *result) bool {
+	return (res.Deps != nil && res.Deps.SONAME != "") || strings.Contains(path.Base(res.Filename), ".so")
+}
+
+// hasCompiler checks if the compiler of an examined ELF file is known, with a version
+func hasCompiler(res *result) bool {
+	return res.CompilerName != "" && res.CompilerVersion != ""
+}
+
+// providers maps the sonames of the examined libraries to their index in
+// subjects, so that dependencies on them can refer to them
+func providers(subjects []*result) map[string]int {
+	bySONAME := make(map[string]int)
+	for i, res := range subjects {
+		if res.Deps != nil && res.Deps.SONAME != "" {
+			if _, ok := bySONAME[res.Deps.SONAME]; !ok {
+				bySONAME[res.Deps.SONAME] = i
+			}
+		}
+	}
+	return bySONAME
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// toolVersion returns the version of elfinfo, like "1.2.3"
+func toolVersion() string {
+	fields := strings.Fields(versionString)
+	return fields[len(fields)-1]
+}
+
+// cdxHash is a checksum in a CycloneDX document
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// cdxProperty is a name and a value in a CycloneDX document
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// cdxComponent is a component in a CycloneDX document
+type cdxComponent struct {
+	Type       string         `json:"type"`
+	BOMRef     string         `json:"bom-ref,omitempty"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	Scope      string         `json:"scope,omitempty"`
+	Hashes     []cdxHash      `json:"hashes,omitempty"`
+	PURL       string         `json:"purl,omitempty"`
+	Properties []cdxProperty  `json:"properties,omitempty"`
+	Components []cdxComponent `json:"components,omitempty"`
+}
+
+// cdxDependency lists what a component in a CycloneDX document depends on
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cdxFormula describes how components in a CycloneDX document were made.
+// Here, it is used for the compilers.
+type cdxFormula struct {
+	BOMRef     string         `json:"bom-ref"`
+	Components []cdxComponent `json:"components"`
+	Properties []cdxProperty  `json:"properties"`
+}
+
+// cdxBOM is a CycloneDX document
+type cdxBOM struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string `json:"timestamp"`
+		Tools     struct {
+			Components []cdxComponent `json:"components"`
+		} `json:"tools"`
+	} `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies,omitempty"`
+	Formulation  []cdxFormula    `json:"formulation,omitempty"`
+}
+
+// cycloneDX creates a CycloneDX document with a component per ELF file.
+// Embedded Go modules and Rust crates are nested components, needed
+// libraries that were not examined are components of their own, and the
+// compilers are listed in the formulation.
+func cycloneDX(results []*result) *cdxBOM {
+	bom := &cdxBOM{BOMFormat: "CycloneDX", SpecVersion: "1.5", SerialNumber: "urn:uuid:" + newUUID(), Version: 1}
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Tools.Components = []cdxComponent{{Type: "application", Name: "elfinfo", Version: toolVersion()}}
+	bom.Components = []cdxComponent{}
+
+	subjects := sbomSubjects(results)
+	bySONAME := providers(subjects)
+	refs := make([]string, len(subjects))
+	for i := range subjects {
+		refs[i] = "elf-" + strconv.Itoa(i+1)
+	}
+	var libraries []cdxComponent
+	libraryRefs := make(map[string]string)
+	compilers := make(map[string]*cdxFormula)
+	var compilerOrder []string
+
+	for i, res := range subjects {
+		ref := refs[i]
+		c := cdxComponent{Type: "application", BOMRef: ref, Name: path.Base(res.Filename)}
+		if isLibrary(res) {
+			c.Type = "library"
+		}
+		if res.Hashes != nil {
+			c.Hashes = []cdxHash{{"SHA-1", res.Hashes.SHA1}, {"SHA-256", res.Hashes.SHA256}}
+		}
+		c.Properties = []cdxProperty{{"elfinfo:path", res.Filename}, {"elfinfo:compiler", res.Compiler}, {"elfinfo:machine", res.Machine}, {"elfinfo:class", res.Class}}
+		if res.Debug != nil && res.Debug.BuildID != "" {
+			c.Properties = append(c.Properties, cdxProperty{"elfinfo:build_id", res.Debug.BuildID})
+		}
+		if res.Layer != "" {
+			c.Properties = append(c.Properties, cdxProperty{"elfinfo:layer", res.Layer})
+		}
+
+		dependsOn := []string{}
+		main, modules := embeddedModules(res)
+		if main != nil {
+			c.Version, c.PURL = main.version, main.purl
+		}
+		for j, m := range modules {
+			module := cdxComponent{Type: "library", BOMRef: ref + "-module-" + strconv.Itoa(j+1), Name: m.name, Version: m.version, PURL: m.purl}
+			if m.build {
+				module.Scope = "excluded"
+			}
+			c.Components = append(c.Components, module)
+			if m.direct {
+				dependsOn = append(dependsOn, module.BOMRef)
+			}
+			if len(m.deps) > 0 {
+				d := cdxDependency{Ref: module.BOMRef}
+				for _, k := range m.deps {
+					d.DependsOn = append(d.DependsOn, ref+"-module-"+strconv.Itoa(k+1))
+				}
+				bom.Dependencies = append(bom.Dependencies, d)
+			}
+		}
+
+		if res.Deps != nil {
+			for _, name := range res.Deps.Needed {
+				if k, ok := bySONAME[name]; ok {
+					dependsOn = append(dependsOn, refs[k])
+					continue
+				}
+				libraryRef, ok := libraryRefs[name]
+				if !ok {
+					libraryRef = "needed-" + strconv.Itoa(len(libraryRefs)+1)
+					libraryRefs[name] = libraryRef
+					libraries = append(libraries, cdxComponent{Type: "library", BOMRef: libraryRef, Name: name})
+				}
+				dependsOn = append(dependsOn, libraryRef)
+			}
+		}
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: ref, DependsOn: dependsOn})
+
+		if hasCompiler(res) {
+			key := res.CompilerName + " " + res.CompilerVersion
+			formula, ok := compilers[key]
+			if !ok {
+				n := strconv.Itoa(len(compilers) + 1)
+				formula = &cdxFormula{
+					BOMRef:     "formula-" + n,
+					Components: []cdxComponent{{Type: "application", BOMRef: "compiler-" + n, Name: res.CompilerName, Version: res.CompilerVersion}},
+				}
+				compilers[key] = formula
+				compilerOrder = append(compilerOrder, key)
+			}
+			formula.Properties = append(formula.Properties, cdxProperty{"elfinfo:built", ref})
+		}
+		bom.Components = append(bom.Components, c)
+	}
+	bom.Components = append(bom.Components, libraries...)
+	for _, key := range compilerOrder {
+		bom.Formulation = append(bom.Formulation, *compilers[key])
+	}
+	return bom
+}
+
+// spdxChecksum is a checksum in an SPDX document
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+// spdxExternalRef is a reference to a package in an SPDX document, like a package URL
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+// spdxPackage is a package in an SPDX document
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	PackageFileName       string            `json:"packageFileName,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+// spdxRelationship is a relationship between two elements in an SPDX document
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxDocument is an SPDX document
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+// spdxPURL returns the package URL of a package as an external reference
+func spdxPURL(purl string) []spdxExternalRef {
+	if purl == "" {
+		return nil
+	}
+	return []spdxExternalRef{{"PACKAGE-MANAGER", "purl", purl}}
+}
+
+// spdx creates an SPDX document with a package per ELF file. Embedded Go
+// modules and Rust crates are statically linked packages, needed libraries
+// that were not examined are dynamically linked packages, and the compilers
+// are build tools.
+func spdx(results []*result) *spdxDocument {
+	doc := &spdxDocument{SPDXVersion: "SPDX-2.3", DataLicense: "CC0-1.0", SPDXID: "SPDXRef-DOCUMENT", Name: "elfinfo"}
+	doc.DocumentNamespace = "https://spdx.org/spdxdocs/elfinfo-" + newUUID()
+	doc.CreationInfo.Created = time.Now().UTC().Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: elfinfo-" + toolVersion()}
+	doc.Packages = []spdxPackage{}
+	doc.Relationships = []spdxRelationship{}
+	relate := func(a, typ, b string) {
+		doc.Relationships = append(doc.Relationships, spdxRelationship{a, typ, b})
+	}
+
+	subjects := sbomSubjects(results)
+	bySONAME := providers(subjects)
+	ids := make([]string, len(subjects))
+	for i := range subjects {
+		ids[i] = "SPDXRef-ELF-" + strconv.Itoa(i+1)
+	}
+	var libraries, compilers []spdxPackage
+	libraryIDs := make(map[string]string)
+	compilerIDs := make(map[string]string)
+
+	for i, res := range subjects {
+		id := ids[i]
+		p := spdxPackage{SPDXID: id, Name: path.Base(res.Filename), PackageFileName: res.Filename, DownloadLocation: "NOASSERTION", PrimaryPackagePurpose: "APPLICATION"}
+		if isLibrary(res) {
+			p.PrimaryPackagePurpose = "LIBRARY"
+		}
+		if res.Hashes != nil {
+			p.Checksums = []spdxChecksum{{"SHA1", res.Hashes.SHA1}, {"SHA256", res.Hashes.SHA256}}
+		}
+		comments := []string{"compiler: " + res.Compiler, "machine: " + res.Machine, "class: " + res.Class}
+		if res.Debug != nil && res.Debug.BuildID != "" {
+			comments = append(comments, "build-id: "+res.Debug.BuildID)
+		}
+		if res.Layer != "" {
+			comments = append(comments, "layer: "+res.Layer)
+		}
+		p.Comment = strings.Join(comments, "\n")
+		main, modules := embeddedModules(res)
+		if main != nil {
+			p.VersionInfo, p.ExternalRefs = main.version, spdxPURL(main.purl)
+		}
+		doc.Packages = append(doc.Packages, p)
+		relate("SPDXRef-DOCUMENT", "DESCRIBES", id)
+
+		for j, m := range modules {
+			moduleID := id + "-Module-" + strconv.Itoa(j+1)
+			doc.Packages = append(doc.Packages, spdxPackage{SPDXID: moduleID, Name: m.name, VersionInfo: m.version, DownloadLocation: "NOASSERTION", ExternalRefs: spdxPURL(m.purl), PrimaryPackagePurpose: "LIBRARY"})
+			if m.build {
+				relate(moduleID, "BUILD_DEPENDENCY_OF", id)
+			} else {
+				relate(id, "STATIC_LINK", moduleID)
+			}
+			for _, k := range m.deps {
+				relate(moduleID, "DEPENDS_ON", id+"-Module-"+strconv.Itoa(k+1))
+			}
+		}
+
+		if res.Deps != nil {
+			for _, name := range res.Deps.Needed {
+				if k, ok := bySONAME[name]; ok {
+					relate(id, "DYNAMIC_LINK", ids[k])
+					continue
+				}
+				libraryID, ok := libraryIDs[name]
+				if !ok {
+					libraryID = "SPDXRef-Library-" + strconv.Itoa(len(libraryIDs)+1)
+					libraryIDs[name] = libraryID
+					libraries = append(libraries, spdxPackage{SPDXID: libraryID, Name: name, DownloadLocation: "NOASSERTION", PrimaryPackagePurpose: "LIBRARY"})
+				}
+				relate(id, "DYNAMIC_LINK", libraryID)
+			}
+		}
+
+		if hasCompiler(res) {
+			key := res.CompilerName + " " + res.CompilerVersion
+			compilerID, ok := compilerIDs[key]
+			if !ok {
+				compilerID = "SPDXRef-Compiler-" + strconv.Itoa(len(compilerIDs)+1)
+				compilerIDs[key] = compilerID
+				compilers = append(compilers, spdxPackage{SPDXID: compilerID, Name: res.CompilerName, VersionInfo: res.CompilerVersion, DownloadLocation: "NOASSERTION", PrimaryPackagePurpose: "APPLICATION"})
+			}
+			relate(compilerID, "BUILD_TOOL_OF", id)
+		}
+	}
+	doc.Packages = append(append(doc.Packages, libraries...), compilers...)
+	return doc
+}
+
+// writeSBOM writes an SBOM in the given format, for the examined files
+func writeSBOM(w io.Writer, format string, results []*result) error {
+	var doc interface{}
+	switch format {
+	case sbomCycloneDX:
+		doc = cycloneDX(results)
+	case sbomSPDX:
+		doc = spdx(results)
+	default:
+		return fmt.Errorf("unknown SBOM format: %s", format)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// scanOptions controls how directories are traversed
+type scanOptions struct {
+	followSymlinks bool // follow symbolic links to files and directories
+	oneFileSystem  bool // do not cross filesystem boundaries
+}
+
+// isDirectory checks if the given path is a directory (following symlinks)
+func isDirectory(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// walk calls fn for every regular file found in the given paths.
+// Directories are traversed recursively, in lexical order.
+// explicit is true for files that were given directly, and false for files
+// that were found while traversing a directory.
+func walk(paths []string, opts scanOptions, fn func(filename string, explicit bool)) error {
+	for _, path := range paths {
+		// stdin
+		if path == "-" {
+			fn(path, true)
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			fn(path, true)
+			continue
+		}
+		dev, _ := fileDevice(fi)
+		visited := make(map[string]bool)
+		if err := walkDir(path, dev, opts, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir traverses a single directory. rootDev is the device of the
+// top level directory, used when opts.oneFileSystem is set. visited keeps
+// track of resolved directory paths, to avoid symlink loops.
+func walkDir(dir string, rootDev uint64, opts scanOptions, visited map[string]bool, fn func(string, bool)) error {
+	if realPath, err := filepath.EvalSymlinks(dir); err == nil {
+		if visited[realPath] {
+			return nil
+		}
+		visited[realPath] = true
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// Unreadable directories are reported, but do not stop the scan
+		fmt.Fprintln(os.Stderr, err)
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, fi := range entries {
+		path := filepath.Join(dir, fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if !opts.followSymlinks {
+				continue
+			}
+			if fi, err = os.Stat(path); err != nil {
+				// Dangling symlink
+				continue
+			}
+		}
+		switch {
+		case fi.IsDir():
+			if opts.oneFileSystem {
+				if dev, ok := fileDevice(fi); ok && dev != rootDev {
+					continue
+				}
+			}
+			if err := walkDir(path, rootDev, opts, visited, fn); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			fn(path, false)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"debug/elf"
+	"sort"
+	"strings"
+)
+
+const (
+	// dynamic flags, see elf.h
+	dfBindNow = 0x8        // DF_BIND_NOW in DT_FLAGS
+	df1Now    = 0x1        // DF_1_NOW in DT_FLAGS_1
+	df1PIE    = 0x08000000 // DF_1_PIE in DT_FLAGS_1
+
+	ptGNUProperty = 0x6474e553 // PT_GNU_PROPERTY
+
+	ntGNUPropertyType0        = 5          // NT_GNU_PROPERTY_TYPE_0
+	gnuPropertyX86Feature1And = 0xc0000002 // GNU_PROPERTY_X86_FEATURE_1_AND
+	gnuPropertyX86IBT         = 0x1        // GNU_PROPERTY_X86_FEATURE_1_IBT
+	gnuPropertyX86SHSTK       = 0x2        // GNU_PROPERTY_X86_FEATURE_1_SHSTK
+)
+
+// RELRO levels
+const (
+	relroNone    = "none"
+	relroPartial = "partial"
+	relroFull    = "full"
+)
+
+// securityInfo lists the hardening features of an ELF file, like checksec
+type securityInfo struct {
+	RELRO        string   `json:"relro"`               // "none", "partial" or "full"
+	NX           bool     `json:"nx"`                  // the stack is not executable
+	PIE          bool     `json:"pie"`                 // position independent executable
+	SharedObject bool     `json:"shared_object"`       // a shared library, which is always position independent
+	Relocatable  bool     `json:"relocatable"`         // an object file, where PIE does not apply
+	Canary       bool     `json:"canary"`              // compiled with stack protection
+	Fortify      bool     `json:"fortify"`             // compiled with FORTIFY_SOURCE
+	Fortified    []string `json:"fortified,omitempty"` // the fortified functions that are used
+	IBT          bool     `json:"ibt"`                 // CET indirect branch tracking
+	SHSTK        bool     `json:"shstk"`               // CET shadow stack
+	RPATH        []string `json:"rpath,omitempty"`
+	RUNPATH      []string `json:"runpath,omitempty"`
+}
+
+// symbolNames returns the names of both the dynamic symbols and the regular
+// symbols, since static executables only have the latter
+func symbolNames(f *elf.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, symbols := range [][]elf.Symbol{dynamicSymbols(f), regularSymbols(f)} {
+		for _, sym := range symbols {
+			// Strip symbol versions, like "@GLIBC_2.4"
+			names[strings.SplitN(sym.Name, "@", 2)[0]] = true
+		}
+	}
+	return names
+}
+
+// dynamicSymbols returns the dynamic symbols, or nil
+func dynamicSymbols(f *elf.File) []elf.Symbol {
+	symbols, _ := f.DynamicSymbols()
+	return symbols
+}
+
+// regularSymbols returns the symbols in the symbol table, or nil
+func regularSymbols(f *elf.File) []elf.Symbol {
+	symbols, _ := f.Symbols()
+	return symbols
+}
+
+// x86Features returns the x86 feature bits from the GNU property notes
+func x86Features(f *elf.File) uint32 {
+	var notes []elfNote
+	if sec := f.Section(".note.gnu.property"); sec != nil {
+		if data, err := metadataSection(sec); err == nil {
+			notes = parseNotes(f, data, sec.Addralign)
+		}
+	} else {
+		for _, prog := range f.Progs {
+			if prog.Type != elf.ProgType(ptGNUProperty) {
+				continue
+			}
+			if data, err := segmentData(prog); err == nil {
+				notes = parseNotes(f, data, prog.Align)
+			}
+		}
+	}
+	// Properties are 4 byte aligned in 32-bit files and 8 byte aligned in 64-bit files
+	align := uint64(8)
+	if f.Class == elf.ELFCLASS32 {
+		align = 4
+	}
+	var features uint32
+	for _, note := range notes {
+		if note.Name != "GNU" || note.Type != ntGNUPropertyType0 {
+			continue
+		}
+		desc := note.Desc
+		// The sizes are added as uint64, so that they can not wrap around
+		for len(desc) >= 8 {
+			prType := f.ByteOrder.Uint32(desc)
+			prSize := uint64(f.ByteOrder.Uint32(desc[4:]))
+			if 8+prSize > uint64(len(desc)) {
+				break
+			}
+			if prType == gnuPropertyX86Feature1And && prSize >= 4 {
+				features |= f.ByteOrder.Uint32(desc[8:])
+			}
+			next := (8 + prSize + align - 1) &^ (align - 1)
+			if next > uint64(len(desc)) {
+				break
+			}
+			desc = desc[next:]
+		}
+	}
+	return features
+}
+
+// security examines the hardening features of the given ELF file
+func security(f *elf.File) *securityInfo {
+	info := &securityInfo{RELRO: relroNone}
+	dyn := dynamicEntries(f)
+
+	hasInterp := false
+	hasStack := false
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_GNU_RELRO:
+			info.RELRO = relroPartial
+		case elf.PT_GNU_STACK:
+			hasStack = true
+			info.NX = prog.Flags&elf.PF_X == 0
+		case elf.PT_INTERP:
+			hasInterp = true
+		}
+	}
+	// Without PT_GNU_STACK, the stack is executable on most architectures
+	if !hasStack {
+		info.NX = false
+	}
+	// Object files have no segments, but the .note.GNU-stack section tells
+	// the linker if the object needs an executable stack
+	if f.Type == elf.ET_REL {
+		info.Relocatable = true
+		if sec := f.Section(".note.GNU-stack"); sec != nil {
+			info.NX = sec.Flags&elf.SHF_EXECINSTR == 0
+		}
+	}
+
+	flags, _ := dynValue(dyn, elf.DT_FLAGS)
+	flags1, _ := dynValue(dyn, elf.DT_FLAGS_1)
+	_, bindNow := dynValue(dyn, elf.DT_BIND_NOW)
+	bindNow = bindNow || flags&dfBindNow != 0 || flags1&df1Now != 0
+	if info.RELRO == relroPartial && bindNow {
+		info.RELRO = relroFull
+	}
+
+	if f.Type == elf.ET_DYN {
+		// Older linkers do not set DF_1_PIE, but only executables have an interpreter
+		if flags1&df1PIE != 0 || hasInterp {
+			info.PIE = true
+		} else {
+			info.SharedObject = true
+		}
+	}
+
+	names := symbolNames(f)
+	info.Canary = names["__stack_chk_fail"] || names["__stack_chk_guard"] || names["__intel_security_cookie"]
+	for name := range names {
+		if strings.HasPrefix(name, "__") && strings.HasSuffix(name, "_chk") && !strings.HasPrefix(name, "__stack_chk") {
+			info.Fortified = append(info.Fortified, name)
+		}
+	}
+	sort.Strings(info.Fortified)
+	info.Fortify = len(info.Fortified) > 0
+
+	features := x86Features(f)
+	info.IBT = features&gnuPropertyX86IBT != 0
+	info.SHSTK = features&gnuPropertyX86SHSTK != 0
+
+	info.RPATH = dynStrings(f, elf.DT_RPATH)
+	info.RUNPATH = dynStrings(f, elf.DT_RUNPATH)
+	return info
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// defaultSignaturePriority is the priority of signatures that do not have
+// one. It is higher than the priorities of the built-in detectors, so that
+// in-house signatures are tried first.
+const defaultSignaturePriority = 100
+
+// parseSignature converts a mapping from a signatures file to a signature
+func parseSignature(value interface{}) (compiler.Signature, error) {
+	s := compiler.Signature{Priority: defaultSignaturePriority}
+	mapping, ok := value.(map[string]interface{})
+	if !ok {
+		return s, errors.New("expected a mapping")
+	}
+	for key, v := range mapping {
+		values, err := toStrings(v)
+		if err == nil && len(values) != 1 {
+			err = errors.New("expected a single value")
+		}
+		if err == nil {
+			switch key {
+			case "name":
+				s.Name = values[0]
+			case "section":
+				s.Section = values[0]
+			case "pattern":
+				s.Pattern = values[0]
+			case "marker":
+				s.Marker = values[0]
+			case "confidence":
+				s.Confidence = values[0]
+			case "priority":
+				s.Priority, err = strconv.Atoi(values[0])
+			default:
+				err = errors.New("unknown field")
+			}
+		}
+		if err != nil {
+			return s, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return s, nil
+}
+
+// loadSignatures reads a signatures file, in YAML or JSON, and registers a
+// detector for each signature. The signatures are either the top level
+// list, or the list in the "signatures" field.
+func loadSignatures(filename string) error {
+	items, err := loadList(filename, "signatures")
+	if err != nil {
+		return err
+	}
+	// Check all the signatures before registering any of them
+	var loaded []*compiler.Detector
+	for i, item := range items {
+		s, err := parseSignature(item)
+		if err != nil {
+			return fmt.Errorf("%s: signature %d: %v", filename, i+1, err)
+		}
+		d, err := s.Detector()
+		if err != nil {
+			return fmt.Errorf("%s: signature %d: %v", filename, i+1, err)
+		}
+		loaded = append(loaded, d)
+	}
+	for _, d := range loaded {
+		compiler.Register(d)
+	}
+	return nil
+}
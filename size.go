@@ -0,0 +1,289 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xyproto/elfinfo/compiler"
+)
+
+// Languages that symbols can be grouped by
+const (
+	langGo   = "go"   // grouped by package, from the Go pclntab
+	langRust = "rust" // grouped by crate, from the mangled symbol names
+	langCPP  = "c++"  // grouped by the outermost namespace or class, from the mangled symbol names
+	langC    = "c"    // symbols that are not mangled
+)
+
+// sizeEntry is a number of bytes that is attributed to something
+type sizeEntry struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"` // only for groups
+	Size     uint64 `json:"size"`
+}
+
+// sizeReport attributes the bytes of an ELF file to sections, symbols and
+// groups of symbols, like Go packages, Rust crates and C++ namespaces
+type sizeReport struct {
+	FileSize uint64      `json:"file_size"`
+	Sections []sizeEntry `json:"sections"` // the file size of each section, largest first
+	Other    uint64      `json:"other"`    // the bytes that are not in any section, like headers and padding
+	Symbols  []sizeEntry `json:"symbols"`  // the largest functions and objects
+	Groups   []sizeEntry `json:"groups"`   // the total size of the symbols in each group, largest first
+}
+
+// readerSize returns the size of the data in r, if it can be found
+func readerSize(r io.ReaderAt) (uint64, bool) {
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return uint64(v.Size()), true
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := v.Stat(); err == nil {
+			return uint64(fi.Size()), true
+		}
+	}
+	return 0, false
+}
+
+// sortBySize sorts size entries with the largest first, and then by name and language
+func sortBySize(entries []sizeEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Language < entries[j].Language
+	})
+}
+
+// sizedSymbol is a function or object with a size, as found in a symbol
+// table or in the Go pclntab
+type sizedSymbol struct {
+	name  string
+	addr  uint64
+	size  uint64
+	goPkg string // the Go package, if the symbol was found in the pclntab
+}
+
+// elfSizedSymbols returns the defined functions and objects in the symbol
+// table, or in the dynamic symbol table if the file is stripped. Aliases,
+// with the same address and size, are only included once.
+func elfSizedSymbols(f *elf.File) []sizedSymbol {
+	symbols, err := f.Symbols()
+	if err != nil || len(symbols) == 0 {
+		symbols, _ = f.DynamicSymbols()
+	}
+	type key struct{ addr, size uint64 }
+	seen := make(map[key]bool)
+	var sized []sizedSymbol
+	for _, sym := range symbols {
+		typ := elf.ST_TYPE(sym.Info)
+		if sym.Size == 0 || sym.Section == elf.SHN_UNDEF || sym.Section >= elf.SHN_LORESERVE || (typ != elf.STT_FUNC && typ != elf.STT_OBJECT && typ != elf.STT_TLS) {
+			continue
+		}
+		k := key{sym.Value, sym.Size}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		sized = append(sized, sizedSymbol{name: sym.Name, addr: sym.Value, size: sym.Size})
+	}
+	return sized
+}
+
+// goFunctions returns the functions in the Go pclntab, which is present
+// even if the file is stripped
+func goFunctions(f *elf.File) []sizedSymbol {
+	pclntab := compiler.SectionData(f, ".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil
+	}
+	table, err := gosym.NewTable(compiler.SectionData(f, ".gosymtab"), gosym.NewLineTable(pclntab, text.Addr))
+	if err != nil {
+		return nil
+	}
+	functions := make([]sizedSymbol, 0, len(table.Funcs))
+	for _, fn := range table.Funcs {
+		if fn.End <= fn.Entry {
+			continue
+		}
+		pkg := fn.PackageName()
+		if pkg == "" {
+			pkg = "(unknown)"
+		}
+		functions = append(functions, sizedSymbol{name: fn.Name, addr: fn.Entry, size: fn.End - fn.Entry, goPkg: pkg})
+	}
+	return functions
+}
+
+var (
+	// rustHash matches the hash at the end of Rust symbols in the legacy
+	// mangling scheme, which may be followed by a suffix like ".llvm.123" or ".0"
+	rustHash = regexp.MustCompile(`17h[0-9a-f]{16}E(\..*)?$`)
+	// rustV0Crate matches the crate root in Rust symbols in the v0 mangling scheme
+	rustV0Crate = regexp.MustCompile(`C(?:s[0-9a-zA-Z]*_)?(\d+)`)
+	// cppStd matches the abbreviations for the std namespace in C++ symbols
+	cppStd = regexp.MustCompile(`^S[tabsiod]`)
+)
+
+// firstMangledName parses the first length prefixed identifier in s
+func firstMangledName(s string) string {
+	digits := 0
+	for digits < len(s) && s[digits] >= '0' && s[digits] <= '9' {
+		digits++
+	}
+	n, err := strconv.Atoi(s[:digits])
+	if err != nil || digits+n > len(s) {
+		return ""
+	}
+	return s[digits : digits+n]
+}
+
+// rustLegacyCrate returns the crate of the first path component of a Rust
+// symbol in the legacy mangling scheme. For trait implementations, like
+// "<alloc::string::String as core::fmt::Display>", this is the crate of the
+// type, or of the trait if the type is a primitive type or a type parameter.
+func rustLegacyCrate(component string) string {
+	component = strings.TrimPrefix(strings.TrimPrefix(component, "_"), "$LT$")
+	if parts := strings.SplitN(component, "$u20$as$u20$", 2); len(parts) == 2 && !strings.Contains(parts[0], "..") {
+		component = parts[1]
+	}
+	// Skip references, pointers and slices, like "$RF$" for "&"
+	for strings.HasPrefix(component, "$") {
+		end := strings.IndexByte(component[1:], '$')
+		if end == -1 {
+			break
+		}
+		component = component[end+2:]
+	}
+	if pos := strings.IndexAny(component, ".$"); pos != -1 {
+		component = component[:pos]
+	}
+	if component == "" {
+		return "(unknown)"
+	}
+	return component
+}
+
+// symbolGroup returns the language of a symbol and the group it belongs to:
+// the crate for Rust symbols, the outermost namespace or class for C++
+// symbols, or the symbol itself for C symbols
+func symbolGroup(name string) (string, string) {
+	// Symbols may have a version, like "memcpy@GLIBC_2.14"
+	if pos := strings.IndexByte(name, '@'); pos > 0 {
+		name = name[:pos]
+	}
+	switch {
+	case strings.HasPrefix(name, "_R"):
+		if m := rustV0Crate.FindStringSubmatchIndex(name); m != nil {
+			// An "_" separates the length from identifiers that start with "_" or a digit
+			ident := name[m[2]:m[3]]
+			if rest := name[m[3]:]; strings.HasPrefix(rest, "_") {
+				ident += rest[1:]
+			} else {
+				ident += rest
+			}
+			if crate := firstMangledName(ident); crate != "" {
+				return langRust, crate
+			}
+		}
+		return langRust, "(unknown)"
+	case strings.HasPrefix(name, "_ZN"):
+		rest := strings.TrimLeft(name[3:], "rVKRO")
+		first := firstMangledName(rest)
+		if rustHash.MatchString(name) {
+			return langRust, rustLegacyCrate(first)
+		}
+		if cppStd.MatchString(rest) {
+			return langCPP, "std"
+		}
+		if first == "" {
+			return langCPP, "(unknown)"
+		}
+		return langCPP, first
+	case strings.HasPrefix(name, "_ZZ"):
+		// A static variable in a function, which belongs with the function
+		return symbolGroup("_Z" + name[3:])
+	case strings.HasPrefix(name, "_ZTV"), strings.HasPrefix(name, "_ZTI"), strings.HasPrefix(name, "_ZTS"), strings.HasPrefix(name, "_ZGV"):
+		// A vtable, type info, type name or guard variable, which belongs with the type or variable
+		return symbolGroup("_Z" + name[4:])
+	case strings.HasPrefix(name, "_Z"):
+		if cppStd.MatchString(name[2:]) {
+			return langCPP, "std"
+		}
+		return langCPP, "(global)"
+	}
+	return langC, name
+}
+
+// sizeReportFor attributes the bytes in f to sections, the topN largest
+// symbols and groups of symbols. fileSize is the size of the file, if known.
+func sizeReportFor(f *elf.File, fileSize uint64, topN int) *sizeReport {
+	report := &sizeReport{FileSize: fileSize, Sections: []sizeEntry{}, Symbols: []sizeEntry{}, Groups: []sizeEntry{}}
+	var inSections uint64
+	for _, sec := range f.Sections {
+		if sec.Type == elf.SHT_NULL || sec.Type == elf.SHT_NOBITS {
+			continue
+		}
+		report.Sections = append(report.Sections, sizeEntry{Name: sec.Name, Size: sec.FileSize})
+		inSections += sec.FileSize
+	}
+	sortBySize(report.Sections)
+	if fileSize > inSections {
+		report.Other = fileSize - inSections
+	}
+
+	// Go functions are taken from the pclntab, and grouped by package,
+	// together with the other symbols that have Go names
+	symbols := elfSizedSymbols(f)
+	if functions := goFunctions(f); functions != nil {
+		goAddrs := make(map[uint64]bool, len(functions))
+		for _, fn := range functions {
+			goAddrs[fn.addr] = true
+		}
+		for _, sym := range symbols {
+			if goAddrs[sym.addr] {
+				continue
+			}
+			sym.goPkg = (&gosym.Sym{Name: sym.name}).PackageName()
+			// Symbols like "go:func.*" and "$f64.3ff0000000000000" are generated by the linker
+			if strings.HasPrefix(sym.goPkg, "go:") || strings.HasPrefix(sym.goPkg, "$") || sym.goPkg == "_" {
+				sym.goPkg = "(linker)"
+			}
+			functions = append(functions, sym)
+		}
+		symbols = functions
+	}
+
+	groups := make(map[[2]string]uint64)
+	for _, sym := range symbols {
+		language, group := langGo, sym.goPkg
+		if group == "" {
+			language, group = symbolGroup(sym.name)
+		}
+		// C symbols are all grouped together
+		if language == langC {
+			group = "(c)"
+		}
+		groups[[2]string{language, group}] += sym.size
+		report.Symbols = append(report.Symbols, sizeEntry{Name: sym.name, Size: sym.size})
+	}
+	sortBySize(report.Symbols)
+	if topN >= 0 && len(report.Symbols) > topN {
+		report.Symbols = report.Symbols[:topN]
+	}
+	for k, size := range groups {
+		report.Groups = append(report.Groups, sizeEntry{Name: k[1], Language: k[0], Size: size})
+	}
+	sortBySize(report.Groups)
+	return report
+}
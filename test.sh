@@ -1,4 +1,2 @@
 #!/bin/sh
-for f in /usr/bin/*; do
-  ./elfinfo "$f"
-done
+./elfinfo /usr/bin
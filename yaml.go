@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// yamlLine is a line in a YAML document, without comments and indentation
+type yamlLine struct {
+	number int // for error messages
+	indent int
+	text   string
+}
+
+// yamlParser parses the subset of YAML that is needed for policy and
+// signature files: block mappings and sequences, flow sequences and
+// mappings like "[a, b]" and "{a: b}", comments and plain, single quoted
+// and double quoted scalars. Scalars are returned as strings, mappings as
+// map[string]interface{} and sequences as []interface{}.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// stripComment removes a comment from a line, unless the "#" is quoted
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseYAML parses a YAML document
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripComment(strings.TrimRight(line, "\r")), " \t")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can not be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(line) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	value, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return value, nil
+}
+
+// parseBlock parses a mapping or a sequence at the given indentation
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if line := p.lines[p.pos]; line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// parseSequence parses the "- " items at the given indentation
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !(line.text == "-" || strings.HasPrefix(line.text, "- ")) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			// The item is a block on the following lines
+			p.pos++
+			if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+				items = append(items, "")
+				continue
+			}
+			item, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		if _, _, isKey := splitYAMLKey(rest); isKey || strings.HasPrefix(rest, "- ") {
+			// The item is a block that starts on the same line, like "- name: x"
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + len(line.text) - len(rest), text: rest}
+			item, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		value, err := parseYAMLScalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+		p.pos++
+	}
+	return items, nil
+}
+
+// splitYAMLKey splits "key: value" into the key and the value. Quoted
+// scalars and flow sequences and mappings are values, not keys.
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	pos := strings.Index(text, ": ")
+	if pos == -1 {
+		return "", "", false
+	}
+	return strings.TrimSpace(text[:pos]), strings.TrimSpace(text[pos+2:]), true
+}
+
+// parseMapping parses the "key: value" lines at the given indentation
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	mapping := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.number)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+		if rest != "" {
+			value, err := parseYAMLScalar(rest, line.number)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = value
+			continue
+		}
+		// The value is a block on the following lines. Sequences may be
+		// at the same indentation as the key.
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			isItem := next.text == "-" || strings.HasPrefix(next.text, "- ")
+			if next.indent > indent || (next.indent == indent && isItem) {
+				value, err := p.parseBlock(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				mapping[key] = value
+				continue
+			}
+		}
+		mapping[key] = ""
+	}
+	return mapping, nil
+}
+
+// parseYAMLScalar parses a quoted or plain scalar, or a flow sequence or mapping
+func parseYAMLScalar(text string, number int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("line %d: unterminated sequence", number)
+		}
+		items := []interface{}{}
+		for _, part := range splitFlowItems(text[1 : len(text)-1]) {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			item, err := parseYAMLScalar(part, number)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.HasPrefix(text, "\""):
+		if len(text) < 2 || !strings.HasSuffix(text, "\"") {
+			return nil, fmt.Errorf("line %d: unterminated string", number)
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(text[1 : len(text)-1]), nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("line %d: unterminated string", number)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case strings.HasPrefix(text, "{"):
+		if !strings.HasSuffix(text, "}") {
+			return nil, fmt.Errorf("line %d: unterminated mapping", number)
+		}
+		mapping := make(map[string]interface{})
+		for _, part := range splitFlowItems(text[1 : len(text)-1]) {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			key, rest, ok := splitYAMLKey(part)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected \"key: value\"", number)
+			}
+			value, err := parseYAMLScalar(rest, number)
+			if err != nil {
+				return nil, err
+			}
+			mapping[key] = value
+		}
+		return mapping, nil
+	}
+	return text, nil
+}
+
+// splitFlowItems splits the contents of a flow sequence or mapping on the
+// commas that are not quoted or nested
+func splitFlowItems(text string) []string {
+	var items []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, text[start:])
+}
+
+// loadList reads a list of items from a YAML or JSON file, like a policy or
+// a signatures file. The file is parsed as JSON if it starts with "{" or
+// "[". The list is either the top level, or the value of the given field
+// in a top level mapping. Returns an error if there are no items.
+func loadList(filename, field string) ([]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		doc, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if mapping, ok := doc.(map[string]interface{}); ok {
+		doc = mapping[field]
+	}
+	items, ok := doc.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("%s: no %s", filename, field)
+	}
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// yamlMap and yamlList are short names for the parsed YAML values
+type (
+	yamlMap  = map[string]interface{}
+	yamlList = []interface{}
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want interface{}
+	}{
+		{"empty", "", nil},
+		{"only comments", "# a comment\n---\n", nil},
+		{"mapping", "a: 1\nb: two\n", yamlMap{"a": "1", "b": "two"}},
+		{"nested mappings", "a:\n  b:\n    c: d\n  e: f\ng: h\n", yamlMap{"a": yamlMap{"b": yamlMap{"c": "d"}, "e": "f"}, "g": "h"}},
+		{"empty value", "a:\nb: c\n", yamlMap{"a": "", "b": "c"}},
+		{"sequence", "- a\n- b\n", yamlList{"a", "b"}},
+		{"sequence in mapping", "rules:\n  - a\n  - b\n", yamlMap{"rules": yamlList{"a", "b"}}},
+		{"sequence at the key indentation", "rules:\n- a\n- b\nc: d\n", yamlMap{"rules": yamlList{"a", "b"}, "c": "d"}},
+		{"mappings in sequence", "- name: a\n  value: 1\n- name: b\n", yamlList{yamlMap{"name": "a", "value": "1"}, yamlMap{"name": "b"}}},
+		{"nested sequences", "- - a\n  - b\n- c\n", yamlList{yamlList{"a", "b"}, "c"}},
+		{"block item", "-\n  a: b\n-\n", yamlList{yamlMap{"a": "b"}, ""}},
+		{"flow sequence", "a: [b, 'c, d', [e]]\n", yamlMap{"a": yamlList{"b", "c, d", yamlList{"e"}}}},
+		{"flow mapping", "a: {b: c, d: [e, f]}\n", yamlMap{"a": yamlMap{"b": "c", "d": yamlList{"e", "f"}}}},
+		{"flow mapping in sequence", "- {name: a, max: 1.2}\n", yamlList{yamlMap{"name": "a", "max": "1.2"}}},
+		{"empty flow sequence", "a: []\n", yamlMap{"a": yamlList{}}},
+		{"double quoted", `a: "b: \"c\"\\\n"` + "\n", yamlMap{"a": "b: \"c\"\\\n"}},
+		{"single quoted", "a: 'it''s # not a comment'\n", yamlMap{"a": "it's # not a comment"}},
+		{"quoted key-like item", "- 'a: b'\n", yamlList{"a: b"}},
+		{"comments", "# header\na: b # trailing\nc: d#e\n", yamlMap{"a": "b", "c": "d#e"}},
+		{"windows line endings", "a: b\r\nc: d\r\n", yamlMap{"a": "b", "c": "d"}},
+	}
+	for _, test := range tests {
+		got, err := parseYAML([]byte(test.doc))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"deeper indentation", "a: b\n  c: d\n", "line 2: unexpected indentation"},
+		{"shallower indentation", "  a: b\nc: d\n", "line 2: unexpected indentation"},
+		{"sequence then mapping", "- a\nb: c\n", "line 2: unexpected indentation"},
+		{"tab indentation", "a:\n\tb: c\n", "line 2: tabs can not be used for indentation"},
+		{"not a key", "a: b\nc\n", "line 2: expected \"key: value\""},
+		{"duplicate key", "a: b\na: c\n", "line 2: duplicate key \"a\""},
+		{"unterminated string", "a: \"b\n", "line 1: unterminated string"},
+		{"unterminated single quoted string", "a: 'b\n", "line 1: unterminated string"},
+		{"unterminated sequence", "a: [b, c\n", "line 1: unterminated sequence"},
+		{"unterminated mapping", "a: {b: c\n", "line 1: unterminated mapping"},
+		{"flow mapping without key", "a: {b}\n", "line 1: expected \"key: value\""},
+	}
+	for _, test := range tests {
+		if _, err := parseYAML([]byte(test.doc)); err == nil || err.Error() != test.want {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLoadList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elfinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name string
+		doc  string
+		want yamlList // nil if an error is expected
+	}{
+		{"yaml list", "- a\n- b\n", yamlList{"a", "b"}},
+		{"yaml field", "# rules\nrules:\n  - name: a\n", yamlList{yamlMap{"name": "a"}}},
+		{"json list", ` ["a", {"b": 1}]`, yamlList{"a", yamlMap{"b": 1.0}}},
+		{"json field", `{"rules": ["a"], "other": true}`, yamlList{"a"}},
+		{"other field", "signatures:\n  - a\n", nil},
+		{"empty list", "rules: []\n", nil},
+		{"empty file", "", nil},
+		{"bad json", `{"rules": [}`, nil},
+		{"bad yaml", "rules:\n  - a\n b: c\n", nil},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1))
+		if err := ioutil.WriteFile(filename, []byte(test.doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadList(filename, "rules")
+		switch {
+		case test.want == nil && err == nil:
+			t.Errorf("%s: got %#v, want an error", test.name, got)
+		case test.want == nil && !strings.HasPrefix(err.Error(), filename+": "):
+			t.Errorf("%s: the error %q does not start with the filename", test.name, err)
+		case test.want != nil && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.want != nil && !reflect.DeepEqual(got, test.want):
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+	if _, err := loadList(filepath.Join(dir, "missing"), "rules"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}